@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"syscall"
 	"time"
 
 	"github.com/go-log/log"
@@ -160,6 +161,7 @@ func (c *Chain) dialWithOptions(ctx context.Context, network, address string, op
 		}
 		d := &net.Dialer{
 			Timeout: timeout,
+			Control: options.Control,
 			// LocalAddr: laddr, // TODO: optional local address
 		}
 		return d.DialContext(ctx, network, ipAddr)
@@ -328,6 +330,7 @@ type ChainOptions struct {
 	Timeout  time.Duration
 	Hosts    *Hosts
 	Resolver Resolver
+	Control  func(network, address string, c syscall.RawConn) error
 }
 
 // ChainOption allows a common way to set chain options.
@@ -354,6 +357,13 @@ func HostsChainOption(hosts *Hosts) ChainOption {
 	}
 }
 
+// ControlChainOption specifies the control function of the dialer used when the chain is empty.
+func ControlChainOption(control func(network, address string, c syscall.RawConn) error) ChainOption {
+	return func(opts *ChainOptions) {
+		opts.Control = control
+	}
+}
+
 // ResolverChainOption specifies the Resolver used by Chain.Dial.
 func ResolverChainOption(resolver Resolver) ChainOption {
 	return func(opts *ChainOptions) {
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"net"
 	"net/url"
+	"syscall"
 	"time"
 
 	"github.com/ginuerzh/gosocks4"
@@ -42,6 +43,13 @@ type HandlerOptions struct {
 	IPs           []string
 	TCPMode       bool
 	IPRoutes      []IPRoute
+
+	// UserPermissions overrides Whitelist and Blacklist per authenticated user, if set.
+	UserPermissions func(user string) (whitelist, blacklist *Permissions)
+	// ForwardConnWrapper wraps the connections accepted on remote forward listeners, if set.
+	ForwardConnWrapper func(user string, conn net.Conn) net.Conn
+	// DialControl is called with the resolved address before connecting to a target directly, the connection is refused if it returns an error.
+	DialControl func(network, address string, c syscall.RawConn) error
 }
 
 // HandlerOption allows a common way to set handler options.
@@ -106,6 +114,20 @@ func BlacklistHandlerOption(blacklist *Permissions) HandlerOption {
 	}
 }
 
+// UserPermissionsHandlerOption sets the UserPermissions option of HandlerOptions.
+func UserPermissionsHandlerOption(f func(user string) (whitelist, blacklist *Permissions)) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.UserPermissions = f
+	}
+}
+
+// ForwardConnWrapperHandlerOption sets the ForwardConnWrapper option of HandlerOptions.
+func ForwardConnWrapperHandlerOption(f func(user string, conn net.Conn) net.Conn) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.ForwardConnWrapper = f
+	}
+}
+
 // BypassHandlerOption sets the bypass option of HandlerOptions.
 func BypassHandlerOption(bypass *Bypass) HandlerOption {
 	return func(opts *HandlerOptions) {
@@ -155,6 +177,13 @@ func ResolverHandlerOption(resolver Resolver) HandlerOption {
 	}
 }
 
+// DialControlHandlerOption sets the DialControl option of HandlerOptions.
+func DialControlHandlerOption(control func(network, address string, c syscall.RawConn) error) HandlerOption {
+	return func(opts *HandlerOptions) {
+		opts.DialControl = control
+	}
+}
+
 // HostsHandlerOption sets the Hosts option of HandlerOptions.
 func HostsHandlerOption(hosts *Hosts) HandlerOption {
 	return func(opts *HandlerOptions) {
@@ -228,6 +228,9 @@ func (tr *sshForwardTransporter) Handshake(conn net.Conn, options ...HandshakeOp
 		Timeout:         timeout,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
+	if opts.SSHConfig != nil && opts.SSHConfig.HostKeyCallback != nil {
+		config.HostKeyCallback = opts.SSHConfig.HostKeyCallback
+	}
 	if opts.User != nil {
 		config.User = opts.User.Username()
 		if password, _ := opts.User.Password(); password != "" {
@@ -342,6 +345,9 @@ func (tr *sshTunnelTransporter) Handshake(conn net.Conn, options ...HandshakeOpt
 		Timeout:         timeout,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
+	if opts.SSHConfig != nil && opts.SSHConfig.HostKeyCallback != nil {
+		config.HostKeyCallback = opts.SSHConfig.HostKeyCallback
+	}
 	if opts.User != nil {
 		config.User = opts.User.Username()
 		if password, _ := opts.User.Password(); password != "" {
@@ -589,7 +595,7 @@ func (h *sshForwardHandler) handleForward(conn ssh.Conn, chans <-chan ssh.NewCha
 				}
 
 				go ssh.DiscardRequests(requests)
-				go h.directPortForwardChannel(channel, fmt.Sprintf("%s:%d", p.Host1, p.Port1))
+				go h.directPortForwardChannel(channel, conn.User(), fmt.Sprintf("%s:%d", p.Host1, p.Port1))
 			default:
 				log.Log("[ssh] Unknown channel type:", t)
 				newChannel.Reject(ssh.UnknownChannelType, fmt.Sprintf("unknown channel type: %s", t))
@@ -600,12 +606,21 @@ func (h *sshForwardHandler) handleForward(conn ssh.Conn, chans <-chan ssh.NewCha
 	conn.Wait()
 }
 
-func (h *sshForwardHandler) directPortForwardChannel(channel ssh.Channel, raddr string) {
+// permissions returns the whitelist and blacklist applied to the given user.
+func (h *sshForwardHandler) permissions(user string) (whitelist, blacklist *Permissions) {
+	if h.options.UserPermissions != nil {
+		return h.options.UserPermissions(user)
+	}
+	return h.options.Whitelist, h.options.Blacklist
+}
+
+func (h *sshForwardHandler) directPortForwardChannel(channel ssh.Channel, user string, raddr string) {
 	defer channel.Close()
 
 	log.Logf("[ssh-tcp] %s - %s", h.options.Node.Addr, raddr)
 
-	if !Can("tcp", raddr, h.options.Whitelist, h.options.Blacklist) {
+	whitelist, blacklist := h.permissions(user)
+	if !Can("tcp", raddr, whitelist, blacklist) {
 		log.Logf("[ssh-tcp] Unauthorized to tcp connect to %s", raddr)
 		return
 	}
@@ -642,9 +657,10 @@ func (h *sshForwardHandler) tcpipForwardRequest(sshConn ssh.Conn, req *ssh.Reque
 	t := tcpipForward{}
 	ssh.Unmarshal(req.Payload, &t)
 
-	addr := fmt.Sprintf("%s:%d", t.Host, t.Port)
+	addr := net.JoinHostPort(t.Host, strconv.Itoa(int(t.Port)))
 
-	if !Can("rtcp", addr, h.options.Whitelist, h.options.Blacklist) {
+	whitelist, blacklist := h.permissions(sshConn.User())
+	if !Can("rtcp", addr, whitelist, blacklist) {
 		log.Logf("[ssh-rtcp] Unauthorized to tcp bind to %s", addr)
 		req.Reply(false, nil)
 		return
@@ -684,6 +700,9 @@ func (h *sshForwardHandler) tcpipForwardRequest(sshConn ssh.Conn, req *ssh.Reque
 			if err != nil { // Unable to accept new connection - listener is likely closed
 				return
 			}
+			if h.options.ForwardConnWrapper != nil {
+				conn = h.options.ForwardConnWrapper(sshConn.User(), conn)
+			}
 
 			go func(conn net.Conn) {
 				defer conn.Close()
@@ -724,6 +743,8 @@ type SSHConfig struct {
 	TLSConfig      *tls.Config
 	Key            ssh.Signer
 	AuthorizedKeys map[string]bool
+	// HostKeyCallback is used by SSH clients to verify the server host key, any key is accepted if it is nil.
+	HostKeyCallback ssh.HostKeyCallback
 }
 
 type sshTunnelListener struct {
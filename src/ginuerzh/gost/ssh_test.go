@@ -7,7 +7,10 @@ import (
 	"net"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func sshDirectForwardRoundtrip(targetURL string, data []byte) error {
@@ -45,6 +48,204 @@ func TestSSHDirectForward(t *testing.T) {
 	}
 }
 
+func sshDirectForwardWithUserRoundtrip(targetURL string, data []byte, user *url.Userinfo, handler Handler) error {
+	ln, err := TCPListener("")
+	if err != nil {
+		return err
+	}
+
+	client := &Client{
+		Connector:   SSHDirectForwardConnector(),
+		Transporter: SSHForwardTransporter(),
+	}
+
+	server := &Server{
+		Listener: ln,
+		Handler:  handler,
+	}
+
+	go server.Run()
+	defer server.Close()
+
+	conn, err := client.Dial(server.Addr().String())
+	if err != nil {
+		return err
+	}
+	cc, err := client.Handshake(conn,
+		AddrHandshakeOption(server.Addr().String()),
+		UserHandshakeOption(user),
+	)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer cc.Close()
+
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return err
+	}
+	cc, err = client.Connect(cc, u.Host)
+	if err != nil {
+		return err
+	}
+
+	return httpRoundtrip(cc, targetURL, data)
+}
+
+func TestSSHDirectForwardUserPermissions(t *testing.T) {
+	httpSrv := httptest.NewServer(httpTestHandler)
+	defer httpSrv.Close()
+
+	sendData := make([]byte, 128)
+	rand.Read(sendData)
+
+	u, _ := url.Parse(httpSrv.URL)
+	allowed, _ := ParsePermissions("tcp:*:" + u.Port())
+	denied, _ := ParsePermissions("tcp:*:1")
+
+	handler := SSHForwardHandler(
+		AuthenticatorHandlerOption(NewLocalAuthenticator(map[string]string{"alice": "a", "bob": "b"})),
+		UserPermissionsHandlerOption(func(user string) (*Permissions, *Permissions) {
+			if user == "alice" {
+				return allowed, nil
+			}
+			return denied, nil
+		}),
+	)
+
+	if err := sshDirectForwardWithUserRoundtrip(httpSrv.URL, sendData, url.UserPassword("alice", "a"), handler); err != nil {
+		t.Error(err)
+	}
+	if err := sshDirectForwardWithUserRoundtrip(httpSrv.URL, sendData, url.UserPassword("bob", "b"), handler); err == nil {
+		t.Error("should failed")
+	}
+	if err := sshDirectForwardWithUserRoundtrip(httpSrv.URL, sendData, url.UserPassword("alice", "b"), handler); err == nil {
+		t.Error("should failed")
+	}
+}
+
+func sshRemoteForwardBind(handler Handler, user, password, bindAddr string) error {
+	ln, err := TCPListener("")
+	if err != nil {
+		return err
+	}
+
+	server := &Server{
+		Listener: ln,
+		Handler:  handler,
+	}
+
+	go server.Run()
+	defer server.Close()
+
+	client, err := ssh.Dial("tcp", server.Addr().String(), &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	rl, err := client.Listen("tcp", bindAddr)
+	if err != nil {
+		return err
+	}
+	// gost does not handle cancel-tcpip-forward, the listener is closed with the connection
+	defer rl.Close()
+
+	conn, err := net.Dial("tcp", bindAddr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func freeTCPPort(t *testing.T, host string) int {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestSSHRemoteForwardUserPermissions(t *testing.T) {
+	alicePort := freeTCPPort(t, "127.0.0.1")
+	bobPort := freeTCPPort(t, "127.0.0.1")
+
+	permissions := map[string]*Permissions{
+		"alice": {Permission{
+			Actions: StringSet{"rtcp"},
+			Hosts:   StringSet{"127.0.0.1"},
+			Ports:   PortSet{PortRange{Min: alicePort, Max: alicePort}},
+		}},
+		"bob": {Permission{
+			Actions: StringSet{"rtcp"},
+			Hosts:   StringSet{"127.0.0.1"},
+			Ports:   PortSet{PortRange{Min: bobPort, Max: bobPort}},
+		}},
+	}
+	handler := SSHForwardHandler(
+		AuthenticatorHandlerOption(NewLocalAuthenticator(map[string]string{"alice": "a", "bob": "b"})),
+		UserPermissionsHandlerOption(func(user string) (*Permissions, *Permissions) {
+			if p, ok := permissions[user]; ok {
+				return p, nil
+			}
+			return &Permissions{}, nil
+		}),
+	)
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		bindAddr string
+		allowed  bool
+	}{
+		{"own host and port", "alice", "a", net.JoinHostPort("127.0.0.1", strconv.Itoa(alicePort)), true},
+		{"all interfaces", "alice", "a", net.JoinHostPort("0.0.0.0", strconv.Itoa(alicePort)), false},
+		{"another host", "alice", "a", net.JoinHostPort("127.0.0.2", strconv.Itoa(alicePort)), false},
+		{"another port", "alice", "a", net.JoinHostPort("127.0.0.1", strconv.Itoa(bobPort)), false},
+		{"port of another user", "bob", "b", net.JoinHostPort("127.0.0.1", strconv.Itoa(alicePort)), false},
+		{"password of another user", "alice", "b", net.JoinHostPort("127.0.0.1", strconv.Itoa(alicePort)), false},
+		{"unknown user", "carol", "a", net.JoinHostPort("127.0.0.1", strconv.Itoa(alicePort)), false},
+	}
+	for _, tt := range tests {
+		err := sshRemoteForwardBind(handler, tt.user, tt.password, tt.bindAddr)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.allowed && err == nil {
+			t.Errorf("%s: should failed", tt.name)
+		}
+	}
+}
+
+func TestSSHRemoteForwardUserPermissionsIPv6(t *testing.T) {
+	port := freeTCPPort(t, "::1")
+
+	handler := SSHForwardHandler(
+		AuthenticatorHandlerOption(NewLocalAuthenticator(map[string]string{"alice": "a"})),
+		UserPermissionsHandlerOption(func(user string) (*Permissions, *Permissions) {
+			return &Permissions{Permission{
+				Actions: StringSet{"rtcp"},
+				Hosts:   StringSet{"::1"},
+				Ports:   PortSet{PortRange{Min: port, Max: port}},
+			}}, nil
+		}),
+	)
+
+	if err := sshRemoteForwardBind(handler, "alice", "a", net.JoinHostPort("::1", strconv.Itoa(port))); err != nil {
+		t.Error(err)
+	}
+	if err := sshRemoteForwardBind(handler, "alice", "a", net.JoinHostPort("::", strconv.Itoa(port))); err == nil {
+		t.Error("should failed")
+	}
+}
+
 func BenchmarkSSHDirectForward(b *testing.B) {
 	httpSrv := httptest.NewServer(httpTestHandler)
 	defer httpSrv.Close()
@@ -2,9 +2,13 @@ package client
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -18,12 +22,16 @@ import (
 	"github.com/hashicorp/yamux"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/twinj/uuid"
 )
 
 type Client struct {
 	logger *logrus.Logger
 
-	Tags []string
+	// ID is persisted in the state directory so that the client keeps its identity across reconnects
+	ID       string
+	StateDir string
+	Tags     []string
 
 	conn    net.Conn
 	session *yamux.Session
@@ -32,11 +40,21 @@ type Client struct {
 	serverPort        int
 	reconnectInterval time.Duration
 
+	// TLSEnabled secures the control channel, the server CA is pinned to ServerFingerprint, or on first use
+	TLSEnabled        bool
+	ServerFingerprint string
+	enrolled          bool
+
+	// Token is the enrollment token presented when joining the server for the first time
+	Token string
+
+	gostHostKeyFingerprint string
+
 	allowedPortRangeLBound int
 	allowedPortRangeUBound int
 	portsManager           *utils.PortsManager
 
-	gostTunnels    []*gost.Server
+	gostTunnels    []*portTunnel
 	tunnelListLock chan bool
 
 	novncWebsocketServer *http.Server
@@ -44,6 +62,13 @@ type Client struct {
 	FilebrowserDefaultDir string
 	filebrowserServer     *http.Server
 
+	// GatewayAllowlist lists the destinations reachable through the SOCKS5/HTTP gateway, which is disabled if empty
+	GatewayAllowlist []string
+	gatewayServer    *gost.Server
+
+	// MetricsInterval is the time between the host metrics reports, which are disabled if zero
+	MetricsInterval time.Duration
+
 	ctx  context.Context
 	stop context.CancelFunc
 }
@@ -59,6 +84,7 @@ func NewClient(serverIP string, serverPort int, allowedPortRangeLBound int, allo
 	client.serverIP = serverIP
 	client.serverPort = serverPort
 	client.reconnectInterval = 5 * time.Second
+	client.MetricsInterval = DefaultMetricsInterval
 
 	client.allowedPortRangeLBound = allowedPortRangeLBound
 	client.allowedPortRangeUBound = allowedPortRangeUBound
@@ -80,25 +106,38 @@ func (client *Client) SetAllowedPortRange(lbound int, ubound int) {
 	if lbound == 0 && ubound == 65535 {
 		return
 	}
-	for i := lbound; i <= ubound; i++ {
-		client.portsManager.AddAllowedPort(i)
-	}
+	client.portsManager.AddAllowedRange(utils.PortRange{Low: lbound, High: ubound})
 }
 
-func (client *Client) AddTunnel(tunnel *gost.Server) {
+func (client *Client) AddTunnel(tunnel *portTunnel) {
 	<-client.tunnelListLock
 	defer func() { client.tunnelListLock <- true }()
 
 	client.gostTunnels = append(client.gostTunnels, tunnel)
 }
 
-func (client *Client) RemoveTunnel(tunnel *gost.Server) {
+func (client *Client) RemoveTunnel(tunnel *portTunnel) {
+	<-client.tunnelListLock
+	defer func() { client.tunnelListLock <- true }()
+
+	for i, t := range client.gostTunnels {
+		if t == tunnel {
+			client.gostTunnels = append(client.gostTunnels[:i], client.gostTunnels[i+1:]...)
+			break
+		}
+	}
+}
+
+// CloseTunnel tears down the tunnel, closing a tunnel which does not exist is not an error
+func (client *Client) CloseTunnel(tunnel models.PortTunnelInfo) {
 	<-client.tunnelListLock
 	defer func() { client.tunnelListLock <- true }()
 
 	for i, t := range client.gostTunnels {
-		if t.Addr().String() == tunnel.Addr().String() {
+		if t.ServerPort == tunnel.ServerPort && t.ClientPort == tunnel.ClientPort && t.Listen == tunnel.Listen {
+			t.Close()
 			client.gostTunnels = append(client.gostTunnels[:i], client.gostTunnels[i+1:]...)
+			break
 		}
 	}
 }
@@ -125,21 +164,137 @@ func (client *Client) Reconnect() {
 		time.Sleep(client.reconnectInterval)
 		client.logger.Info("Reconnecting...")
 		c := NewClient(client.serverIP, client.serverPort, client.allowedPortRangeLBound, client.allowedPortRangeUBound, client.Tags, client.logger)
+		c.ID = client.ID
+		c.StateDir = client.StateDir
+		c.TLSEnabled = client.TLSEnabled
+		c.ServerFingerprint = client.ServerFingerprint
+		c.Token = client.Token
 		c.FilebrowserDefaultDir = client.FilebrowserDefaultDir
+		c.GatewayAllowlist = client.GatewayAllowlist
+		c.MetricsInterval = client.MetricsInterval
 		c.Start()
 	}(client)
 }
 
+// DefaultStateDir returns the directory used for persisting the client identity when none is specified
+func DefaultStateDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(utils.GetCurrentExecutablePath(), ".joebot")
+	}
+	return filepath.Join(home, ".joebot")
+}
+
+func (client *Client) stateFilePath(name string) string {
+	if client.StateDir == "" {
+		client.StateDir = DefaultStateDir()
+	}
+	return filepath.Join(client.StateDir, name)
+}
+
+// loadIdentity reads the client ID from the state directory, generating a new one on first run
+func (client *Client) loadIdentity() error {
+	if client.ID != "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(client.stateFilePath("client-id"))
+	if err == nil && strings.TrimSpace(string(b)) != "" {
+		client.ID = strings.TrimSpace(string(b))
+		return nil
+	}
+
+	client.ID = uuid.NewV4().String()
+	return client.saveIdentity()
+}
+
+func (client *Client) saveIdentity() error {
+	idFilePath := client.stateFilePath("client-id")
+	if err := os.MkdirAll(filepath.Dir(idFilePath), 0700); err != nil {
+		return errors.Wrap(err, "Unable to create state directory")
+	}
+	return ioutil.WriteFile(idFilePath, []byte(client.ID), 0600)
+}
+
+// handshake presents the client identity to the server before the yamux session is set up
+func (client *Client) handshake() error {
+	handshake := models.Handshake{ClientID: client.ID, Token: client.Token, Tags: client.Tags}
+	if secret, err := ioutil.ReadFile(client.stateFilePath("client-secret")); err == nil {
+		handshake.Secret = strings.TrimSpace(string(secret))
+	}
+	var err error
+	var key *ecdsa.PrivateKey
+	if client.TLSEnabled && !client.enrolled {
+		if key, handshake.CSR, err = client.createCertificateRequest(); err != nil {
+			return err
+		}
+	}
+	if err = task.SendObject(utils.StructToBytes(handshake), client.conn, 10*time.Second); err != nil {
+		return err
+	}
+
+	body, err := task.ReceiveObject(client.conn, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	var result models.HandshakeResult
+	if err = utils.BytesToStruct(body, &result); err != nil {
+		return errors.Wrap(err, "Unable to decode handshake result")
+	}
+	if !result.Accepted {
+		return errors.New("Server Rejected Connection: " + result.Message)
+	}
+	client.gostHostKeyFingerprint = result.GostHostKeyFingerprint
+
+	if result.ClientID != client.ID {
+		client.ID = result.ClientID
+		if err = client.saveIdentity(); err != nil {
+			client.logger.Error(errors.Wrap(err, "Failed To Persist Client ID"))
+		}
+	}
+	if result.Secret != "" {
+		if err = ioutil.WriteFile(client.stateFilePath("client-secret"), []byte(result.Secret), 0600); err != nil {
+			return errors.Wrap(err, "Failed To Persist Client Secret")
+		}
+		client.logger.Info("Client Enrolled")
+	}
+	if key != nil && len(result.Certificate) > 0 {
+		if err = client.saveCertificate(key, result.Certificate); err != nil {
+			return errors.Wrap(err, "Failed To Persist Client Certificate")
+		}
+		client.logger.Info("Client Enrolled")
+	}
+	client.logger.Info("Client ID: " + client.ID)
+
+	return client.conn.SetDeadline(time.Time{})
+}
+
 func (client *Client) Start() {
 	var err error
 
+	if err = client.loadIdentity(); err != nil {
+		client.logger.Error(errors.Wrap(err, "Failed To Persist Client ID"))
+	}
+
 	// Get a TCP connection
-	client.conn, err = net.Dial("tcp", client.serverIP+":"+strconv.Itoa(client.serverPort))
+	if client.TLSEnabled {
+		var tlsConfig *tls.Config
+		tlsConfig, err = client.tlsConfig()
+		if err == nil {
+			client.conn, err = tls.Dial("tcp", client.serverIP+":"+strconv.Itoa(client.serverPort), tlsConfig)
+		}
+	} else {
+		client.conn, err = net.Dial("tcp", client.serverIP+":"+strconv.Itoa(client.serverPort))
+	}
 	if client.ExitIfError(err, "Unable to connect to server: "+client.serverIP+":"+strconv.Itoa(client.serverPort)) {
 		return
 	}
 
 	client.logger.Info("Client Dialed.")
+	if client.ExitIfError(client.handshake(), "Handshake with server failed") {
+		return
+	}
+
 	// Setup client side of yamux
 	client.session, err = yamux.Client(client.conn, nil)
 	if client.ExitIfError(err, "Unable to create yamux session") {
@@ -151,13 +306,20 @@ func (client *Client) Start() {
 		client.ExitIfError(err, "Handler OnError")
 	})
 	inHandler.RegisterTask(NewPortTunnelTask(client))
+	inHandler.RegisterTask(NewClosePortTunnelTask(client))
 	inHandler.RegisterTask(NewSSHTunnelTask(client))
 	inHandler.RegisterTask(NewNovncTask(client))
 	inHandler.RegisterTask(NewGottyWebTerminalTask(client))
 	inHandler.RegisterTask(NewFilebrowserTask(client))
+	inHandler.RegisterTask(NewGatewayTask(client))
+	inHandler.RegisterTask(NewTunnelStreamTask(client))
+	inHandler.RegisterTask(NewExecTask(client))
 	inHandler.Start()
 
 	client.UpdateClientInfo()
+	// Recordings of the sessions which ended while disconnected
+	go client.UploadTerminalRecordings()
+	go client.ReportHostMetrics()
 }
 
 func (client *Client) Stop() {
@@ -167,7 +329,7 @@ func (client *Client) Stop() {
 	for _, t := range client.gostTunnels {
 		t.Close()
 	}
-	client.gostTunnels = []*gost.Server{}
+	client.gostTunnels = []*portTunnel{}
 
 	if client.novncWebsocketServer != nil {
 		client.novncWebsocketServer.Shutdown(context.Background())
@@ -179,6 +341,11 @@ func (client *Client) Stop() {
 		client.filebrowserServer = nil
 	}
 
+	if client.gatewayServer != nil {
+		client.gatewayServer.Close()
+		client.gatewayServer = nil
+	}
+
 	client.stop()
 }
 
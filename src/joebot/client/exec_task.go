@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+type ExecTask struct {
+	handleClient *Client
+	*task.Task
+}
+
+func NewExecTask(client *Client) *ExecTask {
+	return &ExecTask{
+		client,
+		task.NewTask(client.ctx, task.ExecRequest, client.logger),
+	}
+}
+
+// execOutputWriter streams the output of a command to the server, the command is killed once the server stops reading it
+type execOutputWriter struct {
+	stream net.Conn
+	cancel context.CancelFunc
+	lock   sync.Mutex
+}
+
+func (w *execOutputWriter) send(output models.ExecOutput) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	err := task.SendObject(utils.StructToBytes(output), w.stream, 30*time.Second)
+	if err != nil {
+		w.cancel()
+	}
+	return err
+}
+
+type execStreamWriter struct {
+	*execOutputWriter
+	name string
+}
+
+func (w execStreamWriter) Write(b []byte) (int, error) {
+	if err := w.send(models.ExecOutput{Stream: w.name, Data: string(b)}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Handle runs the command of the request, and streams its output followed by its exit code
+func (t *ExecTask) Handle(body []byte, stream net.Conn) error {
+	if t.handleClient == nil {
+		return errors.New("handleClient param not set")
+	}
+
+	var request models.ExecRequest
+	if err := utils.BytesToStruct(body, &request); err != nil {
+		return errors.Wrap(err, "Unable to decode request body into ExecRequest object")
+	}
+
+	ctx, cancel := context.WithCancel(t.handleClient.ctx)
+	if request.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(t.handleClient.ctx, time.Duration(request.Timeout)*time.Second)
+	}
+	defer cancel()
+
+	t.handleClient.logger.Infof("Running Command: %s %v", request.Command, request.Args)
+	cmd := exec.CommandContext(ctx, request.Command, request.Args...)
+	cmd.Env = append(os.Environ(), request.Env...)
+	cmd.Dir = request.Dir
+	writer := &execOutputWriter{stream: stream, cancel: cancel}
+	cmd.Stdout = execStreamWriter{writer, models.ExecStdout}
+	cmd.Stderr = execStreamWriter{writer, models.ExecStderr}
+
+	// Nothing else is sent by the server, which closes the stream once it stops waiting for the command
+	go func() {
+		stream.SetReadDeadline(time.Time{})
+		stream.Read(make([]byte, 1))
+		cancel()
+	}()
+
+	start := time.Now()
+	err := cmd.Run()
+	exit := &models.ExecExit{ExitCode: -1, Duration: time.Since(start).Seconds()}
+	if cmd.ProcessState != nil {
+		exit.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		exit.TimedOut = true
+	}
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		exit.Error = err.Error()
+	}
+	return writer.send(models.ExecOutput{Exit: exit})
+}
@@ -48,17 +48,24 @@ func (t *FilebrowserTask) Handle(body []byte, stream net.Conn) error {
 		return errors.New("handleClient param not set")
 	}
 
-	freePort, err := t.handleClient.portsManager.ReservePort()
+	var fbInfo models.FilebrowserInfo
+	// Servers before the web portal reverse proxy send an empty request
+	if len(body) > 0 {
+		if err := utils.BytesToStruct(body, &fbInfo); err != nil {
+			return errors.Wrap(err, "Unable to decode request body into FilebrowserInfo object")
+		}
+	}
+
+	freePort, err := t.handleClient.portsManager.ReservePort("files")
 	if err != nil {
 		return err
 	}
-	filebrowserServer, err := StartFilebrowserService(strconv.Itoa(freePort))
+	filebrowserServer, err := StartFilebrowserService(strconv.Itoa(freePort), fbInfo.BaseURL)
 	if err != nil {
 		return errors.Wrap(err, "Failed to create filebrowser server")
 	}
 	t.handleClient.filebrowserServer = filebrowserServer
 
-	var fbInfo models.FilebrowserInfo
 	fbInfo.FilebrowserPort = freePort
 	fbInfo.DefaultDirectory = t.handleClient.FilebrowserDefaultDir
 	if err = task.SendObject(utils.StructToBytes(fbInfo), stream, 10*time.Second); err != nil {
@@ -68,7 +75,7 @@ func (t *FilebrowserTask) Handle(body []byte, stream net.Conn) error {
 	return nil
 }
 
-func StartFilebrowserService(port string) (*http.Server, error) {
+func StartFilebrowserService(port string, baseURL string) (*http.Server, error) {
 	abs, err := filepath.Abs(filepath.Dir(os.Args[0]))
 	if err != nil {
 		return nil, errors.New("Unable To Get Scope")
@@ -106,7 +113,7 @@ func StartFilebrowserService(port string) (*http.Server, error) {
 	}
 	server := &settings.Server{
 		Root:                  abs,
-		BaseURL:               "",
+		BaseURL:               baseURL,
 		Socket:                "",
 		Port:                  port,
 		Log:                   "",
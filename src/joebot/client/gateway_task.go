@@ -0,0 +1,162 @@
+package client
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ginuerzh/gost"
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+// gatewayRule allows the destinations in a network, on any port if no port range is given
+type gatewayRule struct {
+	network *net.IPNet
+	ports   [][2]int
+}
+
+// GatewayAllowlist is the list of destinations reachable through the SOCKS5/HTTP gateway of the client
+type GatewayAllowlist []gatewayRule
+
+// ParseGatewayAllowlist parses entries in the form CIDR[:PORTS], where PORTS is a comma separated list of ports or port ranges, eg: 192.168.1.0/24:22,8000-8100
+func ParseGatewayAllowlist(entries []string) (GatewayAllowlist, error) {
+	allowlist := GatewayAllowlist{}
+	for _, entry := range entries {
+		slash := strings.Index(entry, "/")
+		if slash < 0 {
+			return nil, errors.New("Invalid Gateway Allowlist Entry, CIDR Expected: " + entry)
+		}
+		cidr, ports := entry, ""
+		if colon := strings.Index(entry[slash:], ":"); colon >= 0 {
+			cidr, ports = entry[:slash+colon], entry[slash+colon+1:]
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid Gateway Allowlist Entry: "+entry)
+		}
+		rule := gatewayRule{network: network}
+		if ports != "" {
+			for _, r := range strings.Split(ports, ",") {
+				bounds := strings.SplitN(r, "-", 2)
+				lbound, err := strconv.Atoi(bounds[0])
+				ubound := lbound
+				if err == nil && len(bounds) == 2 {
+					ubound, err = strconv.Atoi(bounds[1])
+				}
+				if err != nil || lbound <= 0 || ubound > 65535 || lbound > ubound {
+					return nil, errors.New("Invalid Gateway Allowlist Port Range: " + entry)
+				}
+				rule.ports = append(rule.ports, [2]int{lbound, ubound})
+			}
+		}
+		allowlist = append(allowlist, rule)
+	}
+	return allowlist, nil
+}
+
+// Allows tells if the IP and port is in the allowlist
+func (allowlist GatewayAllowlist) Allows(ip net.IP, port int) bool {
+	for _, rule := range allowlist {
+		if !rule.network.Contains(ip) {
+			continue
+		}
+		if len(rule.ports) == 0 {
+			return true
+		}
+		for _, r := range rule.ports {
+			if port >= r[0] && port <= r[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// control checks the destination once resolved, so that host names cannot be used to reach destinations outside of the allowlist
+func (allowlist GatewayAllowlist) control(network, address string, c syscall.RawConn) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !allowlist.Allows(ip, port) {
+		return errors.New("Destination Not Allowed By Gateway Allowlist: " + address)
+	}
+	return nil
+}
+
+// StartGateway serves SOCKS5, SOCKS4 and HTTP CONNECT proxy requests on the address, only connecting to the destinations in the allowlist
+func (client *Client) StartGateway(addr string, allowlist GatewayAllowlist) (*gost.Server, error) {
+	ln, err := gost.TCPListener(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed To Listen On Gateway Address")
+	}
+	// Only plain connections, no BIND nor UDP relay
+	whitelist, err := gost.ParsePermissions("tcp:*:*")
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	s := &gost.Server{Listener: ln}
+	h := gost.AutoHandler(
+		gost.WhitelistHandlerOption(whitelist),
+		gost.DialControlHandlerOption(allowlist.control),
+	)
+	go func() {
+		if err := s.Serve(h); err != nil {
+			client.logger.Error(errors.Wrap(err, "Gateway Stopped Serving"))
+		}
+	}()
+	return s, nil
+}
+
+type GatewayTask struct {
+	handleClient *Client
+	*task.Task
+}
+
+func NewGatewayTask(client *Client) *GatewayTask {
+	return &GatewayTask{
+		client,
+		task.NewTask(client.ctx, task.GatewayRequest, client.logger),
+	}
+}
+
+func (t *GatewayTask) Handle(body []byte, stream net.Conn) error {
+	if t.handleClient == nil {
+		return errors.New("handleClient param not set")
+	}
+
+	var gatewayInfo models.GatewayInfo
+	gatewayInfo.Allowlist = t.handleClient.GatewayAllowlist
+	// The gateway is disabled unless some destinations are allowed, which is told to the server by leaving the port empty
+	if len(gatewayInfo.Allowlist) > 0 {
+		allowlist, err := ParseGatewayAllowlist(gatewayInfo.Allowlist)
+		if err != nil {
+			return err
+		}
+		freePort, err := t.handleClient.portsManager.ReservePort("gateway")
+		if err != nil {
+			return err
+		}
+		gatewayServer, err := t.handleClient.StartGateway("127.0.0.1:"+strconv.Itoa(freePort), allowlist)
+		if err != nil {
+			return errors.Wrap(err, "Failed to create gateway")
+		}
+		t.handleClient.gatewayServer = gatewayServer
+		gatewayInfo.GatewayPort = freePort
+	}
+
+	return task.SendObject(utils.StructToBytes(gatewayInfo), stream, 10*time.Second)
+}
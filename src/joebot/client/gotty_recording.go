@@ -0,0 +1,68 @@
+// +build !windows
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/twinj/uuid"
+
+	gotty_server "github.com/yudai/gotty/server"
+)
+
+// recordingFactory records every session of the gotty backend, recordings are uploaded to the server once closed
+type recordingFactory struct {
+	gotty_server.Factory
+	handleClient *Client
+	title        string
+}
+
+func (factory *recordingFactory) New(params map[string][]string) (gotty_server.Slave, error) {
+	dir := factory.handleClient.stateFilePath("recordings")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "Unable To Create Recordings Directory")
+	}
+	// Sessions are refused rather than left unrecorded
+	recorder, err := newTerminalRecorder(filepath.Join(dir, uuid.NewV4().String()+".cast"), factory.title)
+	if err != nil {
+		return nil, err
+	}
+
+	slave, err := factory.Factory.New(params)
+	if err != nil {
+		recorder.Close()
+		return nil, err
+	}
+	return &recordingSlave{Slave: slave, recorder: recorder, handleClient: factory.handleClient}, nil
+}
+
+// recordingSlave tees the terminal output and resize events of the slave into the recorder
+type recordingSlave struct {
+	gotty_server.Slave
+	recorder     *terminalRecorder
+	handleClient *Client
+}
+
+func (slave *recordingSlave) Read(p []byte) (int, error) {
+	n, err := slave.Slave.Read(p)
+	if n > 0 {
+		slave.recorder.Output(p[:n])
+	}
+	return n, err
+}
+
+func (slave *recordingSlave) ResizeTerminal(columns int, rows int) error {
+	slave.recorder.Resize(columns, rows)
+	return slave.Slave.ResizeTerminal(columns, rows)
+}
+
+func (slave *recordingSlave) Close() error {
+	err := slave.Slave.Close()
+	if recordErr := slave.recorder.Close(); recordErr != nil {
+		slave.handleClient.logger.Error(errors.Wrap(recordErr, "Failed To Complete Terminal Recording"))
+	}
+	go slave.handleClient.UploadTerminalRecordings()
+	return err
+}
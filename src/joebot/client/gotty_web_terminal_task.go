@@ -37,7 +37,7 @@ func (t *GottyWebTerminalTask) Handle(body []byte, stream net.Conn) error {
 		return errors.New("handleClient param not set")
 	}
 
-	freePort, err := t.handleClient.portsManager.ReservePort()
+	freePort, err := t.handleClient.portsManager.ReservePort("terminal")
 	if err != nil {
 		return errors.Wrap(err, "Unable To Find Free Port For Gotty Service")
 	}
@@ -77,7 +77,7 @@ func (t *GottyWebTerminalTask) Handle(body []byte, stream net.Conn) error {
 		"argv":     cmdArgs,
 		"hostname": hostname,
 	}
-	srv, err := gotty_server.New(factory, appOptions)
+	srv, err := gotty_server.New(&recordingFactory{Factory: factory, handleClient: t.handleClient, title: hostname}, appOptions)
 	if err != nil {
 		return errors.Wrap(err, "Gotty Server Init Failed")
 	}
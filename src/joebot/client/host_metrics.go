@@ -0,0 +1,53 @@
+package client
+
+import (
+	"time"
+
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+// DefaultMetricsInterval is the time between the host metrics reports of a client
+const DefaultMetricsInterval = 30 * time.Second
+
+// hostMetricsCollector keeps the CPU times of the previous sample, the CPU usage being measured between samples
+type hostMetricsCollector struct {
+	cpuTotal uint64
+	cpuIdle  uint64
+}
+
+// ReportHostMetrics periodically sends the host metrics to the server until the client is stopped
+func (client *Client) ReportHostMetrics() {
+	if client.MetricsInterval <= 0 {
+		return
+	}
+	collector := &hostMetricsCollector{}
+	// The first sample sets the CPU times the usage of the next one is measured from
+	if _, err := collector.collect(); err != nil {
+		client.logger.Info(errors.Wrap(err, "Host Metrics Are Not Reported"))
+		return
+	}
+
+	ticker := time.NewTicker(client.MetricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		metrics, err := collector.collect()
+		if err != nil {
+			client.logger.Error(errors.Wrap(err, "Failed To Collect Host Metrics"))
+			continue
+		}
+		stream, err := task.NewTask(client.ctx, task.HostMetricsReport, client.logger).Request(client.session, utils.StructToBytes(metrics))
+		if err != nil {
+			client.logger.Error(errors.Wrap(err, "Failed To Report Host Metrics"))
+			continue
+		}
+		stream.Close()
+	}
+}
@@ -0,0 +1,230 @@
+package client
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/pkg/errors"
+)
+
+// Filesystems which do not use any disk space
+var pseudoFilesystems = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true, "cgroup2": true, "configfs": true,
+	"debugfs": true, "devpts": true, "devtmpfs": true, "efivarfs": true, "fusectl": true, "hugetlbfs": true,
+	"mqueue": true, "nsfs": true, "proc": true, "pstore": true, "ramfs": true, "rpc_pipefs": true,
+	"securityfs": true, "selinuxfs": true, "squashfs": true, "sysfs": true, "tmpfs": true, "tracefs": true,
+}
+
+// collect reads a sample of the host metrics from /proc
+func (c *hostMetricsCollector) collect() (models.HostMetrics, error) {
+	metrics := models.HostMetrics{Time: time.Now(), CPUCount: runtime.NumCPU()}
+
+	var err error
+	if metrics.CPUPercent, err = c.readCPU(); err != nil {
+		return metrics, errors.Wrap(err, "Unable To Read CPU Usage")
+	}
+	if err = readLoadAverage(&metrics); err != nil {
+		return metrics, errors.Wrap(err, "Unable To Read Load Average")
+	}
+	if err = readMemory(&metrics); err != nil {
+		return metrics, errors.Wrap(err, "Unable To Read Memory Usage")
+	}
+	if metrics.Disks, err = readDisks(); err != nil {
+		return metrics, errors.Wrap(err, "Unable To Read Disk Usage")
+	}
+	if metrics.Network, err = readNetwork(); err != nil {
+		return metrics, errors.Wrap(err, "Unable To Read Network Counters")
+	}
+	if metrics.Uptime, err = readUptime(); err != nil {
+		return metrics, errors.Wrap(err, "Unable To Read Uptime")
+	}
+	return metrics, nil
+}
+
+// readCPU returns the CPU usage since the previous call, from the first line of /proc/stat
+func (c *hostMetricsCollector) readCPU() (float64, error) {
+	content, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(strings.SplitN(string(content), "\n", 2)[0])
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, errors.New("Unexpected Format Of /proc/stat")
+	}
+
+	// user nice system idle iowait irq softirq steal, guest times are included in user and nice
+	var total, idle uint64
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		total += value
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+
+	percent := 0.0
+	if total > c.cpuTotal && c.cpuTotal > 0 {
+		busy := float64(total-c.cpuTotal) - float64(idle-c.cpuIdle)
+		percent = 100 * busy / float64(total-c.cpuTotal)
+	}
+	c.cpuTotal, c.cpuIdle = total, idle
+	return percent, nil
+}
+
+func readLoadAverage(metrics *models.HostMetrics) error {
+	content, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) < 3 {
+		return errors.New("Unexpected Format Of /proc/loadavg")
+	}
+	loads := []*float64{&metrics.Load1, &metrics.Load5, &metrics.Load15}
+	for i, load := range loads {
+		if *load, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readMemory(metrics *models.HostMetrics) error {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	values := map[string]*uint64{
+		"MemTotal":     &metrics.MemoryTotal,
+		"MemAvailable": &metrics.MemoryAvailable,
+		"SwapTotal":    &metrics.SwapTotal,
+		"SwapFree":     &metrics.SwapFree,
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, ok := values[strings.TrimSuffix(fields[0], ":")]
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		*value = kb * 1024
+	}
+	return scanner.Err()
+}
+
+// readDisks returns the usage of the mounted filesystems using disk space, once per mount point
+func readDisks() ([]models.DiskUsage, error) {
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	disks := []models.DiskUsage{}
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || pseudoFilesystems[fields[2]] || seen[fields[1]] {
+			continue
+		}
+		// Spaces in mount points are escaped as \040
+		mount := strings.Replace(fields[1], "\\040", " ", -1)
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(mount, &stat); err != nil || stat.Blocks == 0 {
+			continue
+		}
+		seen[fields[1]] = true
+
+		blockSize := uint64(stat.Bsize)
+		disk := models.DiskUsage{
+			Mount:     mount,
+			Device:    fields[0],
+			FSType:    fields[2],
+			Total:     stat.Blocks * blockSize,
+			Used:      (stat.Blocks - stat.Bfree) * blockSize,
+			Available: stat.Bavail * blockSize,
+		}
+		// Same as df, the space reserved for root is not available to the users
+		if usable := disk.Used + disk.Available; usable > 0 {
+			disk.UsedPercent = 100 * float64(disk.Used) / float64(usable)
+		}
+		disks = append(disks, disk)
+	}
+	return disks, scanner.Err()
+}
+
+// readNetwork returns the counters of the network interfaces but the loopback, from /proc/net/dev
+func readNetwork() ([]models.NetworkCounters, error) {
+	file, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	counters := []models.NetworkCounters{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		fields := strings.Fields(parts[1])
+		if name == "lo" || len(fields) < 16 {
+			continue
+		}
+
+		// Receive: bytes packets errs drop fifo frame compressed multicast, followed by transmit: bytes packets errs ...
+		values := make([]uint64, 16)
+		for i := range values {
+			if values[i], err = strconv.ParseUint(fields[i], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		counters = append(counters, models.NetworkCounters{
+			Interface: name,
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxErrors:  values[2],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxErrors:  values[10],
+		})
+	}
+	return counters, scanner.Err()
+}
+
+func readUptime() (float64, error) {
+	content, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) < 1 {
+		return 0, errors.New("Unexpected Format Of /proc/uptime")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
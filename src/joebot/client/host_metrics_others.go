@@ -0,0 +1,12 @@
+// +build !linux
+
+package client
+
+import (
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/pkg/errors"
+)
+
+func (c *hostMetricsCollector) collect() (models.HostMetrics, error) {
+	return models.HostMetrics{}, errors.New("Host Metrics Are Only Collected On Linux")
+}
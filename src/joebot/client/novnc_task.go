@@ -41,7 +41,7 @@ func (t *NovncTask) Handle(body []byte, stream net.Conn) error {
 		return errors.New("No VNC Server Listening On Port " + strconv.Itoa(websocketInfo.VncServerPort))
 	}
 
-	freePort, err := t.handleClient.portsManager.ReservePort()
+	freePort, err := t.handleClient.portsManager.ReservePort("vnc")
 	if err != nil {
 		return err
 	}
@@ -1,10 +1,10 @@
 package client
 
 import (
-	"fmt"
-	"log"
 	"net"
+	"net/url"
 	"strconv"
+	"sync"
 
 	"github.com/ginuerzh/gost"
 	"github.com/harmonicinc-com/joebot/models"
@@ -13,6 +13,71 @@ import (
 	"github.com/pkg/errors"
 )
 
+// portTunnel is a remote forward of a client port to a server port through a gost tunnel service, or through the yamux session
+type portTunnel struct {
+	ServerPort int
+	ClientPort int
+	// Listens on the client port instead of the server port
+	Listen bool
+
+	server      *gost.Server
+	transporter *tunnelTransporter
+	// Accepts the datagram streams of a UDP tunnel
+	datagramListener net.Listener
+
+	// Address the streams of a tunnel on the yamux data plane are spliced to
+	target string
+	// Accepts the connections of a listening end on the yamux data plane
+	listener net.Listener
+	// Connections of the tunnel on the yamux data plane
+	conns utils.ConnSet
+}
+
+// Close stops the tunnel, closing its SSH connection so that the server port is released by the gost tunnel service
+func (tunnel *portTunnel) Close() error {
+	var err error
+	if tunnel.server != nil {
+		err = tunnel.server.Close()
+		tunnel.transporter.Close()
+	}
+	if tunnel.listener != nil {
+		err = tunnel.listener.Close()
+	}
+	if tunnel.datagramListener != nil {
+		tunnel.datagramListener.Close()
+	}
+	tunnel.conns.Close()
+	return err
+}
+
+// tunnelTransporter keeps the connections to the gost tunnel service, which are otherwise only closed on error
+type tunnelTransporter struct {
+	gost.Transporter
+
+	conns []net.Conn
+	lock  sync.Mutex
+}
+
+func (tr *tunnelTransporter) Dial(addr string, options ...gost.DialOption) (net.Conn, error) {
+	conn, err := tr.Transporter.Dial(addr, options...)
+	if err == nil {
+		tr.lock.Lock()
+		tr.conns = append(tr.conns, conn)
+		tr.lock.Unlock()
+	}
+	return conn, err
+}
+
+func (tr *tunnelTransporter) Close() {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+
+	for _, conn := range tr.conns {
+		conn.Close()
+	}
+	tr.conns = nil
+}
+
 type PortTunnelTask struct {
 	handleClient *Client
 	*task.Task
@@ -36,26 +101,63 @@ func (t *PortTunnelTask) Handle(body []byte, stream net.Conn) error {
 		return errors.Wrap(err, "Unable to decode request body into PortTunnelInfo object")
 	}
 
+	if tunnel.DataPlane == models.DataPlaneYamux {
+		return t.handleStreams(tunnel, stream)
+	}
+
+	gostServerAddr := t.handleClient.serverIP + ":" + strconv.Itoa(tunnel.GostServerPort)
+	transporter := &tunnelTransporter{Transporter: gost.SSHForwardTransporter()}
+	connector := gost.SSHRemoteForwardConnector()
+	if tunnel.Listen {
+		connector = gost.SSHDirectForwardConnector()
+	}
 	chain := gost.NewChain(
 		gost.Node{
 			Protocol:  "forward",
 			Transport: "ssh",
-			Addr:      t.handleClient.serverIP + ":" + strconv.Itoa(tunnel.GostServerPort),
+			Addr:      gostServerAddr,
+			HandshakeOptions: []gost.HandshakeOption{
+				gost.AddrHandshakeOption(gostServerAddr),
+				gost.UserHandshakeOption(url.UserPassword(tunnel.GostUser, tunnel.GostPassword)),
+				gost.SSHConfigHandshakeOption(&gost.SSHConfig{
+					HostKeyCallback: t.handleClient.verifyGostHostKey,
+				}),
+			},
 			Client: &gost.Client{
-				Connector:   gost.SSHRemoteForwardConnector(),
-				Transporter: gost.SSHForwardTransporter(),
+				Connector:   connector,
+				Transporter: transporter,
 			},
 		},
 	)
-	ln, err := gost.TCPRemoteForwardListener(":"+strconv.Itoa(tunnel.ServerPort), chain)
+	pt := &portTunnel{ServerPort: tunnel.ServerPort, ClientPort: tunnel.ClientPort, transporter: transporter}
+	if tunnel.Listen {
+		return t.listen(pt, chain, tunnel, stream)
+	}
+
+	// UDP tunnels forward the TCP relay port on the server to a local listener, which relays the datagrams to the client port
+	bindAddr := net.JoinHostPort(tunnel.BindAddress, strconv.Itoa(tunnel.ServerPort))
+	target := "localhost:" + strconv.Itoa(tunnel.ClientPort)
+	if tunnel.Protocol == models.ProtocolUDP {
+		pt.datagramListener, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return errors.Wrap(err, "Failed To Listen For UDP Tunnel Streams")
+		}
+		go serveDatagramStreams(pt.datagramListener, target)
+		bindAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.RelayPort))
+		target = pt.datagramListener.Addr().String()
+	}
+
+	ln, err := gost.TCPRemoteForwardListener(bindAddr, chain)
 	if err != nil {
-		log.Fatal(err)
+		if pt.datagramListener != nil {
+			pt.datagramListener.Close()
+		}
+		return errors.Wrap(err, "Failed To Forward Server Port")
 	}
 
 	s := &gost.Server{Listener: ln}
-	h := gost.TCPRemoteForwardHandler(
-		"localhost:" + strconv.Itoa(tunnel.ClientPort),
-	)
+	pt.server = s
+	h := gost.TCPRemoteForwardHandler(target)
 	h.Init(
 		gost.AddrHandlerOption(ln.Addr().String()),
 		gost.ChainHandlerOption(chain),
@@ -63,11 +165,61 @@ func (t *PortTunnelTask) Handle(body []byte, stream net.Conn) error {
 	go func() {
 		err := s.Serve(h)
 		if err != nil {
-			fmt.Println(err)
+			t.handleClient.logger.Error(errors.Wrap(err, "Tunnel Stopped Serving"))
 		}
-		t.handleClient.RemoveTunnel(s)
+		t.handleClient.RemoveTunnel(pt)
 	}()
-	t.handleClient.AddTunnel(s)
+	t.handleClient.AddTunnel(pt)
+
+	return task.ConfirmTaskComplete(stream)
+}
+
+// listen accepts connections on localhost:ClientPort, and forwards them to the target address from the server
+func (t *PortTunnelTask) listen(pt *portTunnel, chain *gost.Chain, tunnel models.PortTunnelInfo, stream net.Conn) error {
+	ln, err := gost.TCPListener("127.0.0.1:" + strconv.Itoa(tunnel.ClientPort))
+	if err != nil {
+		return errors.Wrap(err, "Failed To Listen On Client Port")
+	}
+
+	s := &gost.Server{Listener: ln}
+	pt.server = s
+	pt.Listen = true
+	h := gost.TCPDirectForwardHandler(tunnel.TargetAddress)
+	h.Init(gost.ChainHandlerOption(chain))
+	go func() {
+		err := s.Serve(h)
+		if err != nil {
+			t.handleClient.logger.Error(errors.Wrap(err, "Listening Tunnel Stopped Serving"))
+		}
+		t.handleClient.RemoveTunnel(pt)
+	}()
+	t.handleClient.AddTunnel(pt)
+
+	return task.ConfirmTaskComplete(stream)
+}
+
+type ClosePortTunnelTask struct {
+	handleClient *Client
+	*task.Task
+}
+
+func NewClosePortTunnelTask(client *Client) *ClosePortTunnelTask {
+	return &ClosePortTunnelTask{
+		client,
+		task.NewTask(client.ctx, task.ClosePortTunnelRequest, client.logger),
+	}
+}
+
+func (t *ClosePortTunnelTask) Handle(body []byte, stream net.Conn) error {
+	if t.handleClient == nil {
+		return errors.New("handleClient param not set")
+	}
+
+	var tunnel models.PortTunnelInfo
+	if err := utils.BytesToStruct(body, &tunnel); err != nil {
+		return errors.Wrap(err, "Unable to decode request body into PortTunnelInfo object")
+	}
+	t.handleClient.CloseTunnel(tunnel)
 
 	return task.ConfirmTaskComplete(stream)
 }
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+// handleStreams sets up a tunnel on the yamux data plane. The server opens a stream for every connection accepted on the server port,
+// while the connections accepted on the client port of a listening end are carried by streams opened by the client
+func (t *PortTunnelTask) handleStreams(tunnel models.PortTunnelInfo, stream net.Conn) error {
+	var err error
+	pt := &portTunnel{ServerPort: tunnel.ServerPort, ClientPort: tunnel.ClientPort, Listen: tunnel.Listen}
+
+	if tunnel.Listen {
+		pt.listener, err = net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(tunnel.ClientPort))
+		if err != nil {
+			return errors.Wrap(err, "Failed To Listen On Client Port")
+		}
+		go func() {
+			for {
+				conn, err := pt.listener.Accept()
+				if err != nil {
+					break
+				}
+				go t.handleClient.openTunnelStream(pt, conn)
+			}
+			t.handleClient.RemoveTunnel(pt)
+		}()
+	} else {
+		pt.target = "localhost:" + strconv.Itoa(tunnel.ClientPort)
+		if tunnel.Protocol == models.ProtocolUDP {
+			pt.datagramListener, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				return errors.Wrap(err, "Failed To Listen For UDP Tunnel Streams")
+			}
+			go serveDatagramStreams(pt.datagramListener, pt.target)
+			pt.target = pt.datagramListener.Addr().String()
+		}
+	}
+	t.handleClient.AddTunnel(pt)
+
+	return task.ConfirmTaskComplete(stream)
+}
+
+// openTunnelStream carries a connection accepted on a listening end to the server, which connects it to the target of the tunnel
+func (client *Client) openTunnelStream(pt *portTunnel, conn net.Conn) {
+	if !pt.conns.Add(conn) {
+		return
+	}
+	defer pt.conns.Remove(conn)
+
+	request := models.PortTunnelInfo{ClientPort: pt.ClientPort, Listen: true}
+	stream, err := task.NewTask(client.ctx, task.TunnelDialRequest, client.logger).Request(client.session, utils.StructToBytes(request))
+	if err == nil {
+		err = task.WaitTaskCompleteSignal(15*time.Second, stream)
+	}
+	if err != nil {
+		client.logger.Info(errors.Wrap(err, "Failed To Open Tunnel Stream From Client Port "+strconv.Itoa(pt.ClientPort)))
+		conn.Close()
+		if stream != nil {
+			stream.Close()
+		}
+		return
+	}
+	stream.SetReadDeadline(time.Time{})
+	utils.Splice(conn, stream)
+}
+
+// streamTunnel returns the tunnel on the yamux data plane which the streams of the server port belong to
+func (client *Client) streamTunnel(serverPort int) (*portTunnel, error) {
+	<-client.tunnelListLock
+	defer func() { client.tunnelListLock <- true }()
+
+	for _, t := range client.gostTunnels {
+		if t.ServerPort == serverPort && t.target != "" {
+			return t, nil
+		}
+	}
+	return nil, errors.Errorf("No tunnel on server port %d", serverPort)
+}
+
+type TunnelStreamTask struct {
+	handleClient *Client
+	*task.Task
+}
+
+func NewTunnelStreamTask(client *Client) *TunnelStreamTask {
+	return &TunnelStreamTask{
+		client,
+		task.NewTask(client.ctx, task.TunnelStreamRequest, client.logger),
+	}
+}
+
+// Handle splices a stream opened by the server for a connection accepted on the server port to the client port of the tunnel
+func (t *TunnelStreamTask) Handle(body []byte, stream net.Conn) error {
+	if t.handleClient == nil {
+		return errors.New("handleClient param not set")
+	}
+
+	var tunnel models.PortTunnelInfo
+	if err := utils.BytesToStruct(body, &tunnel); err != nil {
+		return errors.Wrap(err, "Unable to decode request body into PortTunnelInfo object")
+	}
+	pt, err := t.handleClient.streamTunnel(tunnel.ServerPort)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", pt.target, 10*time.Second)
+	if err != nil {
+		return errors.Wrap(err, "Failed To Connect To Tunnel Target "+pt.target)
+	}
+	if !pt.conns.Add(conn) {
+		return errors.New("Tunnel Is Closed")
+	}
+	defer pt.conns.Remove(conn)
+
+	if err = task.ConfirmTaskComplete(stream); err != nil {
+		conn.Close()
+		return err
+	}
+	utils.Splice(stream, conn)
+	return nil
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Output beyond this size is dropped from a recording, so that a runaway command cannot fill up the disk
+const maxRecordingSize = 64 * 1024 * 1024
+
+// terminalRecorder writes a terminal session in asciicast v2 format.
+// The header is written along with the first event, as the terminal size is only known once the browser reports it
+type terminalRecorder struct {
+	file   *os.File
+	writer *bufio.Writer
+	path   string
+	title  string
+
+	startedAt     time.Time
+	headerWritten bool
+	size          int
+	truncated     bool
+	// Trailing bytes of an incomplete UTF-8 character, kept for the next output event
+	pending []byte
+
+	lock sync.Mutex
+}
+
+func newTerminalRecorder(path string, title string) (*terminalRecorder, error) {
+	file, err := os.OpenFile(path+".part", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed To Create Terminal Recording")
+	}
+	return &terminalRecorder{
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		path:      path,
+		title:     title,
+		startedAt: time.Now(),
+	}, nil
+}
+
+func (recorder *terminalRecorder) writeHeader(width int, height int) {
+	header := map[string]interface{}{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": recorder.startedAt.Unix(),
+		"title":     recorder.title,
+		"env":       map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": "xterm"},
+	}
+	line, _ := json.Marshal(header)
+	recorder.writer.Write(append(line, '\n'))
+	recorder.headerWritten = true
+}
+
+func (recorder *terminalRecorder) writeEvent(eventType string, data string) {
+	if !recorder.headerWritten {
+		recorder.writeHeader(80, 24)
+	}
+	line, _ := json.Marshal([]interface{}{time.Since(recorder.startedAt).Seconds(), eventType, data})
+	n, _ := recorder.writer.Write(append(line, '\n'))
+	recorder.size += n
+}
+
+// Output records data read from the terminal
+func (recorder *terminalRecorder) Output(data []byte) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if recorder.truncated {
+		return
+	}
+	if recorder.size > maxRecordingSize {
+		recorder.writeEvent("o", "\r\n[Recording truncated]\r\n")
+		recorder.truncated = true
+		return
+	}
+
+	buf := append(recorder.pending, data...)
+	cut := len(buf)
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	recorder.pending = append([]byte{}, buf[cut:]...)
+	if cut > 0 {
+		recorder.writeEvent("o", string(buf[:cut]))
+	}
+}
+
+func (recorder *terminalRecorder) Resize(columns int, rows int) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if !recorder.headerWritten {
+		recorder.writeHeader(columns, rows)
+		return
+	}
+	recorder.writeEvent("r", strconv.Itoa(columns)+"x"+strconv.Itoa(rows))
+}
+
+// Close completes the recording, which is then ready to be uploaded
+func (recorder *terminalRecorder) Close() error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if len(recorder.pending) > 0 && !recorder.truncated {
+		recorder.writeEvent("o", string(recorder.pending))
+	}
+	if !recorder.headerWritten {
+		recorder.writeHeader(80, 24)
+	}
+	if err := recorder.writer.Flush(); err != nil {
+		recorder.file.Close()
+		return err
+	}
+	if err := recorder.file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(recorder.path+".part", recorder.path)
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+// Recordings are streamed to the server in chunks of this size, rather than sent as a whole
+const recordingChunkSize = 256 * 1024
+
+// Shared by the clients created on reconnect, so that a recording is not uploaded twice
+var recordingsUploadLock sync.Mutex
+
+// UploadTerminalRecordings ships the completed recordings to the server, they are only removed once stored by the server
+func (client *Client) UploadTerminalRecordings() {
+	recordingsUploadLock.Lock()
+	defer recordingsUploadLock.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(client.stateFilePath("recordings"), "*.cast"))
+	if err != nil {
+		client.logger.Error(err)
+		return
+	}
+	for _, path := range paths {
+		if err = client.uploadTerminalRecording(path); err != nil {
+			client.logger.Error(errors.Wrap(err, "Failed To Upload Terminal Recording "+path))
+			return
+		}
+		os.Remove(path)
+	}
+}
+
+func (client *Client) uploadTerminalRecording(path string) error {
+	if client.session == nil || client.session.IsClosed() {
+		return errors.New("Not connected to server")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	recording := models.TerminalRecording{
+		ID:      strings.TrimSuffix(filepath.Base(path), ".cast"),
+		EndedAt: fileInfo.ModTime(),
+	}
+
+	stream, err := task.NewTask(client.ctx, task.TerminalRecordingUpload, client.logger).Request(client.session, utils.StructToBytes(recording))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// The cast follows the request in chunks, and ends with an empty one
+	buf := make([]byte, recordingChunkSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if err := task.SendObject(buf[:n], stream, 30*time.Second); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = task.SendObject(nil, stream, 30*time.Second); err != nil {
+		return err
+	}
+	return task.WaitTaskCompleteSignal(60*time.Second, stream)
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// tlsConfig presents the client certificate if the client is enrolled, and pins the server CA
+func (client *Client) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		// The server certificate is verified against the pinned CA in verifyServerCertificate instead
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: client.verifyServerCertificate,
+		MinVersion:            tls.VersionTLS12,
+	}
+
+	certPEM, certErr := ioutil.ReadFile(client.stateFilePath("client.crt"))
+	keyPEM, keyErr := ioutil.ReadFile(client.stateFilePath("client.key"))
+	if certErr == nil && keyErr == nil {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed To Load Client Certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+		client.enrolled = true
+	}
+
+	return config, nil
+}
+
+func (client *Client) verifyServerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) < 2 {
+		return errors.New("Server did not present its CA certificate")
+	}
+	certs := []*x509.Certificate{}
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return errors.Wrap(err, "Failed To Parse Server Certificate")
+		}
+		certs = append(certs, cert)
+	}
+
+	pinnedCAPath := client.stateFilePath("server-ca.crt")
+	var ca *x509.Certificate
+	if caPEM, err := ioutil.ReadFile(pinnedCAPath); err == nil {
+		if ca, err = utils.ParseCertificatePEM(caPEM); err != nil {
+			return errors.Wrap(err, "Failed To Parse Pinned Server CA")
+		}
+	} else {
+		ca = certs[len(certs)-1]
+		if !ca.IsCA {
+			return errors.New("Server presented an invalid CA certificate")
+		}
+		if client.ServerFingerprint == "" {
+			client.logger.Warn("No Server Fingerprint Specified, Trusting Server CA On First Use: " + utils.CertificateFingerprint(ca.Raw))
+		}
+	}
+
+	if client.ServerFingerprint != "" && utils.CertificateFingerprint(ca.Raw) != utils.NormalizeFingerprint(client.ServerFingerprint) {
+		return errors.New("Server CA does not match the pinned fingerprint")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return errors.Wrap(err, "Server Certificate Is Not Issued By The Pinned CA")
+	}
+
+	if !utils.IsFileExist(pinnedCAPath) {
+		if err = os.MkdirAll(filepath.Dir(pinnedCAPath), 0700); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(pinnedCAPath, utils.EncodeCertificatePEM(ca.Raw), 0644)
+	}
+	return nil
+}
+
+// createCertificateRequest generates the client key pair and a CSR to be signed by the server at enrollment
+func (client *Client) createCertificateRequest() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := utils.GeneratePrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: client.ID}}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed To Create Certificate Signing Request")
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+func (client *Client) saveCertificate(key *ecdsa.PrivateKey, certDER []byte) error {
+	keyPEM, err := utils.EncodePrivateKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(client.StateDir, 0700); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(client.stateFilePath("client.key"), keyPEM, 0600); err != nil {
+		return errors.Wrap(err, "Failed To Save Client Key")
+	}
+	return errors.Wrap(ioutil.WriteFile(client.stateFilePath("client.crt"), utils.EncodeCertificatePEM(certDER), 0644), "Failed To Save Client Certificate")
+}
+
+// verifyGostHostKey only accepts the gost tunnel services whose host key fingerprint was handed over at handshake
+func (client *Client) verifyGostHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if client.gostHostKeyFingerprint == "" {
+		return errors.New("Gost host key fingerprint was not received from server")
+	}
+	if ssh.FingerprintSHA256(key) != client.gostHostKeyFingerprint {
+		return errors.New("Gost host key mismatch for " + hostname)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"net"
+
+	"github.com/harmonicinc-com/joebot/utils"
+)
+
+// serveDatagramStreams relays the datagram streams of a UDP tunnel, accepted from the server, to the UDP target.
+// Each stream carries the datagrams of one UDP peer on the server side
+func serveDatagramStreams(ln net.Listener, target string) {
+	for {
+		stream, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go relayDatagramStream(stream, target)
+	}
+}
+
+func relayDatagramStream(stream net.Conn, target string) {
+	defer stream.Close()
+
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		defer stream.Close()
+		buf := make([]byte, utils.MaxDatagramSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if err = utils.WriteDatagram(stream, buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, utils.MaxDatagramSize)
+	for {
+		n, err := utils.ReadDatagram(stream, buf)
+		if err != nil {
+			return
+		}
+		if _, err = conn.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"encoding/binary"
+	"io"
 	"net"
 	"time"
 
@@ -95,7 +96,7 @@ func (handler *IncomingRequestHandler) Start() {
 					if reqBodyLen > 0 {
 						reqBody = make([]byte, reqBodyLen)
 						stream.SetReadDeadline(time.Time{})
-						_, err = stream.Read(reqBody)
+						_, err = io.ReadFull(stream, reqBody)
 						if err != nil {
 							err = errors.Wrap(err, "Unable to read request body from stream")
 							handler.logger.Error(err)
@@ -104,7 +105,9 @@ func (handler *IncomingRequestHandler) Start() {
 					}
 
 					if f, ok := handler.handlers[reqType]; ok {
-						err := f(reqBody, stream)
+						handled := task.NewHandledStream(reqType, stream)
+						err := f(reqBody, handled)
+						handled.Done(err)
 						if err != nil {
 							handler.logger.Error(errors.Wrap(err, "Request Handler Failed To Handle Request"))
 						}
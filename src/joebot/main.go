@@ -1,31 +1,59 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/harmonicinc-com/joebot/client"
 	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/oidc"
 	"github.com/harmonicinc-com/joebot/server"
+	"github.com/harmonicinc-com/joebot/utils"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"github.com/pkg/errors"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
 	app = kingpin.New("joebot", "Command & Control Server/Client For Managing Machines Via Web Interface")
 
-	serverCommand = app.Command("server", "Server Mode")
-	serverPort    = serverCommand.Flag("port", "Port For Listening Slave Machine, Default = 13579").Default("13579").Short('p').Int()
-	webPortalPort = serverCommand.Flag("web-portal-port", "Port For The Web Portal, Default = 8080").Default("8080").Short('w').Int()
-	username      = serverCommand.Flag("user", "Username for login the web portal").String()
-	password      = serverCommand.Flag("pw", "Password for login the web portal").String()
+	serverCommand     = app.Command("server", "Server Mode")
+	serverPort        = serverCommand.Flag("port", "Port For Listening Slave Machine, Default = 13579").Default("13579").Short('p').Int()
+	webPortalPort     = serverCommand.Flag("web-portal-port", "Port For The Web Portal, Default = 8080").Default("8080").Short('w').Int()
+	username          = serverCommand.Flag("user", "Username Of The Web Portal Admin, Created Or Reset On Start").String()
+	password          = serverCommand.Flag("pw", "Password Of The Web Portal Admin").String()
+	serverTLS         = serverCommand.Flag("tls", "Secure The Connections From Clients With TLS, Client Certificates Are Issued By The Server CA").Bool()
+	requireToken      = serverCommand.Flag("require-token", "Only Allow New Clients With A Valid Enrollment Token To Join").Bool()
+	gostCertFile      = serverCommand.Flag("gost-cert", "Certificate File Of The Gost Tunnel Services, Generated In The Data Directory If Not Specified").String()
+	gostKeyFile       = serverCommand.Flag("gost-key", "Private Key File Of The Gost Tunnel Services").String()
+	tunnelBindAddress = serverCommand.Flag("tunnel-bind-address", "IP Address The Tunnels Listen On, Set To 127.0.0.1 To Only Allow Access Through The Web Portal, Default=All Interfaces").String()
+	reverseAllowlist  = serverCommand.Flag("reverse-tunnel-allow", "Server-Side Target Reverse Tunnels May Connect To, In The Form HOST:PORTS, eg: artifacts.corp:443 Or 10.0.0.0/8:3128. Reverse Tunnels Are Disabled If None").Strings()
+	tunnelPortRange   = serverCommand.Flag("tunnel-port-range", "Range Of Server Ports For The Tunnels And The Gost Tunnel Services, In The Form LOW-HIGH, Default=Any Free Port").String()
+	tagPortRanges     = serverCommand.Flag("tag-port-range", "Range Of Server Ports For The Tunnels Of The Clients Having The Tag, In The Form TAG=LOW-HIGH").StringMap()
+	dataPlane         = serverCommand.Flag("data-plane", "Carries The Tunnel Traffic Through A Pool Of Gost SSH Services (gost) Or The Control Channel Of Each Client (yamux), Default=gost").Default("gost").Enum("gost", "yamux")
+	heartbeatInterval = serverCommand.Flag("heartbeat-interval", "Time Between The Heartbeats Of The Clients, 0 Disables Them, Default=10s").Default("10s").Duration()
+	heartbeatMissed   = serverCommand.Flag("heartbeat-max-missed", "Heartbeats Missed In A Row Before A Stalled Client Is Evicted, 0 Only Flags It As Degraded, Default=3").Default("3").Int()
+	degradedRTT       = serverCommand.Flag("degraded-rtt", "Heartbeat Round Trip Time Above Which A Client Is Degraded, Default=500ms").Default("500ms").Duration()
+	oidcIssuer        = serverCommand.Flag("oidc-issuer", "Issuer URL Of The OpenID Connect Provider For Single Sign-On").String()
+	oidcClientID      = serverCommand.Flag("oidc-client-id", "OpenID Connect Client ID").String()
+	oidcClientSecret  = serverCommand.Flag("oidc-client-secret", "OpenID Connect Client Secret, Optional For Public Clients").String()
+	oidcRedirectURL   = serverCommand.Flag("oidc-redirect-url", "Callback URL Registered At The Provider, Default=<Portal_URL>/auth/callback").String()
+	oidcUsernameClaim = serverCommand.Flag("oidc-username-claim", "ID Token Claim Used As Username").Default("preferred_username").String()
+	oidcGroupsClaim   = serverCommand.Flag("oidc-groups-claim", "ID Token Claim Listing The Groups Of The User").Default("groups").String()
+	oidcRoleMapping   = serverCommand.Flag("oidc-role-mapping", "Role Granted To A Group, In The Form GROUP=ROLE Or GROUP=TAG:ROLE").StringMap()
+	dataDir           = serverCommand.Flag("data-dir", "Directory For Persisting Server Data, Default = joebot-data").Default("joebot-data").Short('d').String()
 
 	clientCommand                = app.Command("client", "Client Mode")
 	cServerIP                    = clientCommand.Arg("ip", "Server IP").Required().String()
@@ -34,6 +62,12 @@ var (
 	cAllowedPortRangeUBound      = clientCommand.Flag("allowed-port-upper-bound", "Upper Bound Of Allowed Port Range").Default("65535").Short('u').Int()
 	cTags                        = clientCommand.Flag("tag", "Tags").Strings()
 	cFilebrowserDefaultDirectory = clientCommand.Flag("dir", "Filebrowser Default Directory, Default=/").Default("/").Short('f').String()
+	cTLS                         = clientCommand.Flag("tls", "Connect To The Server With TLS").Bool()
+	cServerFingerprint           = clientCommand.Flag("server-fingerprint", "SHA256 Fingerprint Of The Server CA Certificate, Trusted On First Use If Not Specified").String()
+	cToken                       = clientCommand.Flag("token", "Enrollment Token For Joining The Server").String()
+	cStateDir                    = clientCommand.Flag("state-dir", "Directory For Persisting Client Identity And Certificates, Default=~/.joebot").String()
+	cMetricsInterval             = clientCommand.Flag("metrics-interval", "Time Between The Host Metrics Reports, 0 Disables Them, Default=30s").Default("30s").Duration()
+	cGatewayAllow                = clientCommand.Flag("gateway-allow", "Destination Reachable Through The SOCKS5/HTTP Gateway, In The Form CIDR[:PORTS], eg: 192.168.1.0/24:22,80,8000-8100. The Gateway Is Disabled If None").Strings()
 )
 
 func main() {
@@ -44,25 +78,83 @@ func main() {
 	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
 	case serverCommand.FullCommand():
 		s := server.NewServer(nil)
-		s.Start(*serverPort)
+		s.DataDir = *dataDir
+		s.TLSEnabled = *serverTLS
+		s.RequireToken = *requireToken
+		s.GostCertFile = *gostCertFile
+		s.GostKeyFile = *gostKeyFile
+		if *tunnelBindAddress != "" && net.ParseIP(*tunnelBindAddress) == nil {
+			log.Fatal("Tunnel Bind Address Must Be An IP Address: " + *tunnelBindAddress)
+		}
+		s.TunnelBindAddress = *tunnelBindAddress
+		s.DataPlane = *dataPlane
+		s.HeartbeatInterval = *heartbeatInterval
+		s.HeartbeatMaxMissed = *heartbeatMissed
+		s.DegradedRTT = *degradedRTT
+		reverseTunnelAllowlist, err := server.ParseTargetAllowlist(*reverseAllowlist)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.ReverseTunnelAllowlist = reverseTunnelAllowlist
+		if *tunnelPortRange != "" {
+			if s.TunnelPortRange, err = utils.ParsePortRange(*tunnelPortRange); err != nil {
+				log.Fatal(err)
+			}
+		}
+		s.TagPortRanges = map[string]utils.PortRange{}
+		for tag, portRange := range *tagPortRanges {
+			if s.TagPortRanges[tag], err = utils.ParsePortRange(portRange); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := s.Start(*serverPort); err != nil {
+			log.Fatal(err)
+		}
+
+		if *username != "" && *password != "" {
+			if err := s.EnsureAdminUser(*username, *password); err != nil {
+				log.Fatal(err)
+			}
+		} else if !s.UsersEnabled() && *oidcIssuer == "" {
+			log.Println("Warning: No Web Portal User Configured, The Web Portal Is Open To Everyone")
+		}
+		if (s.UsersEnabled() || *oidcIssuer != "") && !net.ParseIP(*tunnelBindAddress).IsLoopback() {
+			log.Println("Warning: The Tunnel Ports Are Reachable Without The Web Portal Roles, Set --tunnel-bind-address=127.0.0.1 To Enforce Them")
+		}
+
+		var oidcConfig *OIDCConfig
+		if *oidcIssuer != "" {
+			if err := server.ValidateGroupMapping(*oidcRoleMapping); err != nil {
+				log.Fatal(err)
+			}
+			provider, err := oidc.NewProvider(*oidcIssuer, *oidcClientID, *oidcClientSecret)
+			if err != nil {
+				log.Fatal(err)
+			}
+			oidcConfig = &OIDCConfig{
+				Flow:          oidc.NewLoginFlow(provider),
+				RedirectURL:   *oidcRedirectURL,
+				UsernameClaim: *oidcUsernameClaim,
+				GroupsClaim:   *oidcGroupsClaim,
+				GroupMapping:  *oidcRoleMapping,
+			}
+		}
 
 		e := echo.New()
 		v1 := e.Group("/api")
 
-		if username != nil && password != nil && *username != "" && *password != "" {
-			v1.Use(middleware.BasicAuth(func(user, pw string, c echo.Context) (bool, error) {
-				if user == *username && pw == *password {
-					return true, nil
-				}
-				return false, nil
-			}))
-		}
 		webPortalAssetsFS := WebPortalAssetsFS()
 
 		v1.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 			AllowOrigins: []string{"*"},
 			AllowMethods: []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
 		}))
+		v1.Use(authMiddleware(s, oidcConfig))
+		if oidcConfig != nil {
+			registerOIDCRoutes(e, s, oidcConfig)
+		}
+		registerLogoutRoute(e, s, oidcConfig)
+		registerProxyRoutes(e, s, oidcConfig)
 		e.GET("/", func(c echo.Context) error {
 			f, err := webPortalAssetsFS.Open("index.html")
 			if err != nil {
@@ -77,9 +169,57 @@ func main() {
 		})
 		// e.GET("/*", echo.WrapHandler(joebot_html.Handler))
 		e.GET("/*", echo.WrapHandler(http.FileServer(http.FS(webPortalAssetsFS))))
+		// Probes of the load balancer, which are not authenticated
+		e.GET("/healthz", func(c echo.Context) error {
+			return c.String(http.StatusOK, "ok")
+		})
+		e.GET("/readyz", func(c echo.Context) error {
+			if err := s.Ready(); err != nil {
+				return c.String(http.StatusServiceUnavailable, err.Error())
+			}
+			return c.String(http.StatusOK, "ok")
+		})
+		e.GET("/metrics", func(c echo.Context) error {
+			c.Response().Header().Set(echo.HeaderContentType, server.PrometheusContentType)
+			c.Response().WriteHeader(http.StatusOK)
+			return s.WritePrometheusMetrics(c.Response())
+		}, authMiddleware(s, oidcConfig), requireRole(s, models.RoleAdmin))
+		v1.GET("/me", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, currentUser(c))
+		})
 		v1.GET("/clients", func(c echo.Context) error {
-			return c.JSON(http.StatusOK, s.GetClientsList())
+			user := currentUser(c)
+			clients := models.ClientCollection{Clients: []models.ClientInfo{}}
+			for _, info := range s.GetClientsList().Clients {
+				if server.HasClientRole(user, info, models.RoleViewer) {
+					clients.Clients = append(clients.Clients, info)
+				}
+			}
+			return c.JSON(http.StatusOK, clients)
 		})
+		v1.GET("/client/:id", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			record, err := s.GetClientRecord(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			return c.JSON(http.StatusOK, record)
+		}, requireClientRole(s, models.RoleViewer))
+		v1.DELETE("/client/:id", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			err := s.ForgetClient(c.Param("id"))
+			audit(c, s, models.AuditClientForget, c.Param("id"), nil, err)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{err.Error()})
+			}
+			return c.NoContent(http.StatusNoContent)
+		}, requireRole(s, models.RoleAdmin))
 		v1.POST("/client/:id", func(c echo.Context) error {
 			type msg struct {
 				Message string `json:"message"`
@@ -89,38 +229,629 @@ func main() {
 			if err != nil {
 				return c.JSON(http.StatusNotFound, msg{err.Error()})
 			}
-			portStr := c.FormValue("target_client_port")
+			// Reverse tunnels listen on the client port, and connect to the target address from the server
+			targetAddress := c.FormValue("target_address")
+			portParam := "target_client_port"
+			if targetAddress != "" {
+				portParam = "listen_port"
+			}
+			portStr := c.FormValue(portParam)
 			port, err := strconv.Atoi(portStr)
-			if err != nil || port <= 0 {
-				return c.JSON(http.StatusBadRequest, msg{"Invalid target_client_port"})
+			if err != nil || port <= 0 || port > 65535 {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid " + portParam})
+			}
+
+			options := server.TunnelOptions{Protocol: c.FormValue("protocol"), Name: c.FormValue("name"), Description: c.FormValue("description")}
+			if options.Protocol != "" && options.Protocol != models.ProtocolTCP && options.Protocol != models.ProtocolUDP {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid protocol"})
+			}
+			// Both in seconds, empty or 0 means never
+			for param, duration := range map[string]*time.Duration{"ttl": &options.TTL, "idle_timeout": &options.IdleTimeout} {
+				if value := c.FormValue(param); value != "" {
+					seconds, err := strconv.Atoi(value)
+					if err != nil || seconds < 0 {
+						return c.JSON(http.StatusBadRequest, msg{"Invalid " + param})
+					}
+					*duration = time.Duration(seconds) * time.Second
+				}
+			}
+
+			parameters := map[string]string{
+				"client_port":    portStr,
+				"protocol":       options.Protocol,
+				"target_address": targetAddress,
+				"name":           options.Name,
+				"ttl":            c.FormValue("ttl"),
+				"idle_timeout":   c.FormValue("idle_timeout"),
+			}
+			if targetAddress != "" && !s.ReverseTunnelAllowlist.Allows(targetAddress) {
+				err = errors.New("Target address is not in the reverse tunnel allowlist: " + targetAddress)
+				audit(c, s, models.AuditTunnelCreate, client.ID, parameters, err)
+				return c.JSON(http.StatusForbidden, msg{err.Error()})
 			}
 
-			portTunnelInfo, err := client.CreateTunnel(port)
+			var portTunnelInfo models.PortTunnelInfo
+			if targetAddress != "" {
+				portTunnelInfo, err = client.CreateReverseTunnel(port, targetAddress, options)
+			} else {
+				portTunnelInfo, err = client.CreateTunnel(port, options)
+			}
+			parameters["protocol"] = portTunnelInfo.Protocol
+			audit(c, s, models.AuditTunnelCreate, client.ID, parameters, err)
+			if _, exhausted := errors.Cause(err).(*utils.PortRangeExhaustedError); exhausted {
+				return c.JSON(http.StatusServiceUnavailable, msg{err.Error()})
+			}
 			if err != nil {
 				return c.JSON(http.StatusInternalServerError, msg{err.Error()})
 			}
 
 			return c.JSON(http.StatusOK, portTunnelInfo)
-		})
+		}, requireClientRole(s, models.RoleOperator))
+		v1.GET("/client/:id/tunnels", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			client, err := s.GetClientById(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			return c.JSON(http.StatusOK, client.Tunnels())
+		}, requireClientRole(s, models.RoleViewer))
+		v1.GET("/client/:id/metrics", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			client, err := s.GetClientById(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			var since time.Time
+			if c.QueryParam("since") != "" {
+				if since, err = time.Parse(time.RFC3339, c.QueryParam("since")); err != nil {
+					return c.JSON(http.StatusBadRequest, msg{"Invalid since, RFC3339 time is expected"})
+				}
+			}
+			return c.JSON(http.StatusOK, client.HostMetrics(since))
+		}, requireClientRole(s, models.RoleViewer))
+		v1.GET("/client/:id/tunnels/:clientPort", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			client, err := s.GetClientById(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			port, err := strconv.Atoi(c.Param("clientPort"))
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid clientPort"})
+			}
+			tunnel, err := client.GetTunnel(port, c.QueryParam("protocol"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			return c.JSON(http.StatusOK, tunnel)
+		}, requireClientRole(s, models.RoleViewer))
+		v1.DELETE("/client/:id/tunnels/:clientPort", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			client, err := s.GetClientById(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			port, err := strconv.Atoi(c.Param("clientPort"))
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid clientPort"})
+			}
+			protocol := c.QueryParam("protocol")
+			if _, err = client.GetTunnel(port, protocol); err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+
+			tunnel, err := client.CloseTunnel(port, protocol)
+			audit(c, s, models.AuditTunnelClose, client.ID, map[string]string{
+				"client_port": c.Param("clientPort"),
+				"server_port": strconv.Itoa(tunnel.ServerPort),
+				"protocol":    tunnel.Protocol,
+			}, err)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{err.Error()})
+			}
+			return c.NoContent(http.StatusNoContent)
+		}, requireClientRole(s, models.RoleOperator))
+		v1.PUT("/client/:id/tunnels/:clientPort/rate-limit", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			client, err := s.GetClientById(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			port, err := strconv.Atoi(c.Param("clientPort"))
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid clientPort"})
+			}
+			rateLimit, err := strconv.ParseInt(c.FormValue("rate_limit"), 10, 64)
+			if err != nil || rateLimit < 0 {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid rate_limit"})
+			}
+			protocol := c.QueryParam("protocol")
+			if _, err = client.GetTunnel(port, protocol); err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+
+			tunnel, err := client.SetTunnelRateLimit(port, protocol, rateLimit)
+			audit(c, s, models.AuditRateLimitSet, client.ID, map[string]string{
+				"client_port": c.Param("clientPort"),
+				"protocol":    tunnel.Protocol,
+				"rate_limit":  c.FormValue("rate_limit"),
+			}, err)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{err.Error()})
+			}
+			return c.JSON(http.StatusOK, tunnel)
+		}, requireRole(s, models.RoleAdmin))
+		v1.PUT("/client/:id/rate-limit", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			rateLimit, err := strconv.ParseInt(c.FormValue("rate_limit"), 10, 64)
+			if err != nil || rateLimit < 0 {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid rate_limit"})
+			}
+			if _, err = s.GetClientRecord(c.Param("id")); err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+
+			limit, err := s.SetClientRateLimit(c.Param("id"), rateLimit)
+			audit(c, s, models.AuditRateLimitSet, c.Param("id"), map[string]string{"rate_limit": c.FormValue("rate_limit")}, err)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, msg{err.Error()})
+			}
+			return c.JSON(http.StatusOK, limit)
+		}, requireRole(s, models.RoleAdmin))
+		// Tunnels listening on a client, which lead to a port of another client
+		v1.POST("/client/:id/peer-tunnels", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			client, err := s.GetClientById(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			listenPort, err := strconv.Atoi(c.FormValue("listen_port"))
+			if err != nil || listenPort <= 0 || listenPort > 65535 {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid listen_port"})
+			}
+			targetPort, err := strconv.Atoi(c.FormValue("target_client_port"))
+			if err != nil || targetPort <= 0 || targetPort > 65535 {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid target_client_port"})
+			}
+			targetID := c.FormValue("target_client_id")
+			// The tunnel reaches into the target client as well
+			if !s.CanAccessClient(currentUser(c), targetID, models.RoleOperator) {
+				auditDenied(c, s)
+				return c.JSON(http.StatusForbidden, msg{"Forbidden, " + models.RoleOperator + " role is required on client " + targetID})
+			}
+			target, err := s.GetClientById(targetID)
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+
+			options := server.TunnelOptions{Name: c.FormValue("name"), Description: c.FormValue("description")}
+			for param, duration := range map[string]*time.Duration{"ttl": &options.TTL, "idle_timeout": &options.IdleTimeout} {
+				if value := c.FormValue(param); value != "" {
+					seconds, err := strconv.Atoi(value)
+					if err != nil || seconds < 0 {
+						return c.JSON(http.StatusBadRequest, msg{"Invalid " + param})
+					}
+					*duration = time.Duration(seconds) * time.Second
+				}
+			}
+
+			tunnel, err := client.CreatePeerTunnel(listenPort, target, targetPort, options)
+			audit(c, s, models.AuditTunnelCreate, client.ID, map[string]string{
+				"listen_port":        c.FormValue("listen_port"),
+				"target_client_id":   targetID,
+				"target_client_port": c.FormValue("target_client_port"),
+				"name":               options.Name,
+				"ttl":                c.FormValue("ttl"),
+				"idle_timeout":       c.FormValue("idle_timeout"),
+			}, err)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, msg{err.Error()})
+			}
+			return c.JSON(http.StatusOK, tunnel)
+		}, requireClientRole(s, models.RoleOperator))
+		v1.DELETE("/client/:id/peer-tunnels/:listenPort", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			client, err := s.GetClientById(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			port, err := strconv.Atoi(c.Param("listenPort"))
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid listenPort"})
+			}
+			if _, err = client.GetPeerTunnel(port); err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+
+			tunnel, err := client.ClosePeerTunnel(port)
+			audit(c, s, models.AuditTunnelClose, client.ID, map[string]string{
+				"listen_port":        c.Param("listenPort"),
+				"target_client_id":   tunnel.PeerClientID,
+				"target_client_port": strconv.Itoa(tunnel.PeerPort),
+			}, err)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{err.Error()})
+			}
+			return c.NoContent(http.StatusNoContent)
+		}, requireClientRole(s, models.RoleOperator))
+		// Entry point of the services of a client, so that access is checked before redirecting to the service
+		v1.GET("/client/:id/open/:service", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			client, err := s.GetClientById(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			host, port, err := net.SplitHostPort(c.Request().Host)
+			if err != nil {
+				host, port = c.Request().Host, "80"
+				if c.IsTLS() {
+					port = "443"
+				}
+			}
+
+			info := client.GetInfo()
+			action, target := "", ""
+			switch c.Param("service") {
+			case "terminal":
+				action = models.AuditTerminalOpen
+				if info.GottyWebTerminalInfo != nil {
+					target = proxyPath(client.ID, "terminal")
+				}
+			case "files":
+				action = models.AuditFilesOpen
+				if info.FilebrowserInfo != nil {
+					directory := "/files" + (&url.URL{Path: info.FilebrowserInfo.DefaultDirectory}).EscapedPath()
+					if info.FilebrowserInfo.BaseURL != "" {
+						target = strings.TrimSuffix(proxyPath(client.ID, "files"), "/") + directory
+					} else {
+						// Clients before the reverse proxy serve the filebrowser at the root of its tunnel only
+						target = fmt.Sprintf("http://%s:%d%s", host, info.FilebrowserInfo.PortTunnelOnHost.ServerPort, directory)
+					}
+				}
+			case "vnc":
+				action = models.AuditVNCOpen
+				if info.NovncWebsocketInfo != nil {
+					// The noVNC page is served by another site, which cannot send the session cookie along with the websocket
+					sessionID, _ := c.Get("session").(string)
+					ticket, err := s.CreateTicket(currentUser(c), sessionID, client.ID)
+					if err != nil {
+						return err
+					}
+					encrypt := "0"
+					if c.IsTLS() {
+						encrypt = "1"
+					}
+					query := url.Values{
+						"host":        {host},
+						"port":        {port},
+						"path":        {strings.TrimPrefix(proxyPath(client.ID, "vnc"), "/") + "websockify?" + ticketParam + "=" + ticket},
+						"encrypt":     {encrypt},
+						"autoconnect": {"1"},
+					}
+					// noVNC also reads its settings from the fragment, which is neither sent to its site nor in the referrer
+					target = c.Scheme() + "://novnc.com/noVNC/vnc.html#" + query.Encode()
+				}
+			default:
+				return c.JSON(http.StatusBadRequest, msg{"Unknown service: " + c.Param("service")})
+			}
+			if target == "" {
+				err = errors.New("Service is not available on client " + client.ID)
+				audit(c, s, action, client.ID, nil, err)
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			audit(c, s, action, client.ID, nil, nil)
+			return c.Redirect(http.StatusFound, target)
+		}, requireClientRole(s, models.RoleOperator))
+		// Runs a command on the client, its output is streamed as JSON lines with stream=true
+		v1.POST("/client/:id/exec", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			client, err := s.GetClientById(c.Param("id"))
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			request := models.ExecRequest{}
+			if err = c.Bind(&request); err != nil {
+				return c.JSON(http.StatusBadRequest, msg{err.Error()})
+			}
+			if request.Command == "" {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid command"})
+			}
+			if request.Timeout < 0 {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid timeout"})
+			}
+			stream, _ := strconv.ParseBool(c.QueryParam("stream"))
+			parameters := map[string]string{
+				"command": request.Command,
+				"args":    strings.Join(request.Args, " "),
+				"dir":     request.Dir,
+				"timeout": strconv.Itoa(request.Timeout),
+				"stream":  strconv.FormatBool(stream),
+			}
+
+			if !stream {
+				result, err := client.ExecResult(c.Request().Context(), request)
+				if err == nil {
+					parameters["exit_code"] = strconv.Itoa(result.ExitCode)
+				}
+				audit(c, s, models.AuditExec, client.ID, parameters, err)
+				if err != nil {
+					return c.JSON(http.StatusBadGateway, msg{err.Error()})
+				}
+				return c.JSON(http.StatusOK, result)
+			}
+
+			c.Response().Header().Set(echo.HeaderContentType, "application/x-ndjson")
+			c.Response().WriteHeader(http.StatusOK)
+			encoder := json.NewEncoder(c.Response())
+			exit, err := client.Exec(c.Request().Context(), request, func(chunk models.ExecOutput) error {
+				if err := encoder.Encode(chunk); err != nil {
+					return err
+				}
+				c.Response().Flush()
+				return nil
+			})
+			if err == nil {
+				parameters["exit_code"] = strconv.Itoa(exit.ExitCode)
+			} else {
+				exit = models.ExecExit{ExitCode: -1, Error: err.Error()}
+			}
+			audit(c, s, models.AuditExec, client.ID, parameters, err)
+			return encoder.Encode(models.ExecOutput{Exit: &exit})
+		}, requireClientRole(s, models.RoleOperator))
+		v1.GET("/client/:id/recordings", func(c echo.Context) error {
+			recordings, err := s.GetTerminalRecordings(c.Param("id"))
+			if err != nil {
+				return err
+			}
+			return c.JSON(http.StatusOK, recordings)
+		}, requireClientRole(s, models.RoleOperator))
+		v1.GET("/client/:id/recordings/:recording", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			path, err := s.GetTerminalRecordingPath(c.Param("id"), c.Param("recording"))
+			audit(c, s, models.AuditRecordingView, c.Param("id"), map[string]string{"recording": c.Param("recording")}, err)
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			if c.QueryParam("download") != "" {
+				return c.Attachment(path, c.Param("recording")+".cast")
+			}
+			c.Response().Header().Set(echo.HeaderContentType, "application/x-asciicast")
+			return c.File(path)
+		}, requireClientRole(s, models.RoleOperator))
+		v1.GET("/tokens", func(c echo.Context) error {
+			tokens, err := s.GetEnrollmentTokens()
+			if err != nil {
+				return err
+			}
+			return c.JSON(http.StatusOK, tokens)
+		}, requireRole(s, models.RoleAdmin))
+		v1.POST("/tokens", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+			req := struct {
+				Description string   `json:"description"`
+				SingleUse   bool     `json:"single_use"`
+				TTL         int      `json:"ttl"` // In seconds, 0 means never expire
+				Tags        []string `json:"tags"`
+			}{}
+
+			if err := c.Bind(&req); err != nil {
+				return c.JSON(http.StatusBadRequest, msg{err.Error()})
+			}
+			if req.TTL < 0 {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid ttl"})
+			}
+			token, err := s.CreateEnrollmentToken(req.Description, req.SingleUse, time.Duration(req.TTL)*time.Second, req.Tags)
+			audit(c, s, models.AuditTokenCreate, "", map[string]string{
+				"id":          token.ID,
+				"description": req.Description,
+				"single_use":  strconv.FormatBool(req.SingleUse),
+				"ttl":         strconv.Itoa(req.TTL),
+				"tags":        strings.Join(req.Tags, ","),
+			}, err)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, msg{err.Error()})
+			}
+			return c.JSON(http.StatusOK, token)
+		}, requireRole(s, models.RoleAdmin))
+		v1.DELETE("/tokens/:id", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			err := s.RevokeEnrollmentToken(c.Param("id"))
+			audit(c, s, models.AuditTokenRevoke, "", map[string]string{"id": c.Param("id")}, err)
+			if err != nil {
+				return c.JSON(http.StatusNotFound, msg{err.Error()})
+			}
+			return c.NoContent(http.StatusNoContent)
+		}, requireRole(s, models.RoleAdmin))
 		v1.POST("/bulk-install", func(c echo.Context) error {
 			json := models.BulkInstallInfo{}
 
 			if err := c.Bind(&json); err != nil {
 				return err
 			}
+			addresses := []string{}
+			for _, addr := range json.Addresses {
+				addresses = append(addresses, net.JoinHostPort(addr.IP, strconv.Itoa(addr.Port)))
+			}
 			result, err := s.BulkInstallJoebot(json)
+			audit(c, s, models.AuditBulkInstall, "", map[string]string{
+				"server":    net.JoinHostPort(json.JoebotServerIP, strconv.Itoa(json.JoebotServerPort)),
+				"addresses": strings.Join(addresses, ","),
+				"ssh_user":  json.Username,
+			}, err)
 			if err != nil {
 				return err
 			}
 
 			return c.String(http.StatusOK, result)
-		})
+		}, requireRole(s, models.RoleAdmin))
+		v1.GET("/users", func(c echo.Context) error {
+			users, err := s.GetUsers()
+			if err != nil {
+				return err
+			}
+			return c.JSON(http.StatusOK, users)
+		}, requireRole(s, models.RoleAdmin))
+		saveUser := func(c echo.Context, username string) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+			req := struct {
+				Username string            `json:"username"`
+				Password string            `json:"password"`
+				Role     string            `json:"role"`
+				TagRoles map[string]string `json:"tag_roles"`
+			}{}
+
+			if err := c.Bind(&req); err != nil {
+				return c.JSON(http.StatusBadRequest, msg{err.Error()})
+			}
+			if username == "" {
+				username = req.Username
+			}
+			user, err := s.SaveUser(models.User{Username: username, Role: req.Role, TagRoles: req.TagRoles}, req.Password)
+			tagRoles := []string{}
+			for tag, role := range req.TagRoles {
+				tagRoles = append(tagRoles, tag+":"+role)
+			}
+			audit(c, s, models.AuditUserSave, "", map[string]string{
+				"username":         username,
+				"role":             req.Role,
+				"tag_roles":        strings.Join(tagRoles, ","),
+				"password_changed": strconv.FormatBool(req.Password != ""),
+			}, err)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{err.Error()})
+			}
+			return c.JSON(http.StatusOK, user)
+		}
+		v1.POST("/users", func(c echo.Context) error {
+			return saveUser(c, "")
+		}, requireRole(s, models.RoleAdmin))
+		v1.PUT("/users/:username", func(c echo.Context) error {
+			return saveUser(c, c.Param("username"))
+		}, requireRole(s, models.RoleAdmin))
+		v1.DELETE("/users/:username", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			err := s.DeleteUser(c.Param("username"))
+			audit(c, s, models.AuditUserDelete, "", map[string]string{"username": c.Param("username")}, err)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, msg{err.Error()})
+			}
+			return c.NoContent(http.StatusNoContent)
+		}, requireRole(s, models.RoleAdmin))
+		v1.GET("/ports", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, s.PortUsage())
+		}, requireRole(s, models.RoleAdmin))
+		v1.GET("/rate-limits", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, s.RateLimits())
+		}, requireRole(s, models.RoleAdmin))
+		v1.PUT("/tags/:tag/rate-limit", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			rateLimit, err := strconv.ParseInt(c.FormValue("rate_limit"), 10, 64)
+			if err != nil || rateLimit < 0 {
+				return c.JSON(http.StatusBadRequest, msg{"Invalid rate_limit"})
+			}
+
+			limit, err := s.SetTagRateLimit(c.Param("tag"), rateLimit)
+			audit(c, s, models.AuditRateLimitSet, "", map[string]string{"tag": c.Param("tag"), "rate_limit": c.FormValue("rate_limit")}, err)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, msg{err.Error()})
+			}
+			return c.JSON(http.StatusOK, limit)
+		}, requireRole(s, models.RoleAdmin))
+		v1.GET("/audit", func(c echo.Context) error {
+			type msg struct {
+				Message string `json:"message"`
+			}
+
+			filter := server.AuditFilter{
+				Actor:    c.QueryParam("actor"),
+				ClientID: c.QueryParam("client"),
+				Action:   c.QueryParam("action"),
+			}
+			var err error
+			if since := c.QueryParam("since"); since != "" {
+				if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+					return c.JSON(http.StatusBadRequest, msg{"Invalid since, RFC3339 time is expected"})
+				}
+			}
+			if until := c.QueryParam("until"); until != "" {
+				if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+					return c.JSON(http.StatusBadRequest, msg{"Invalid until, RFC3339 time is expected"})
+				}
+			}
+
+			events, err := s.GetAuditEvents(filter)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, msg{err.Error()})
+			}
+			if c.QueryParam("format") == "csv" {
+				c.Response().Header().Set(echo.HeaderContentType, "text/csv")
+				c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit.csv"`)
+				c.Response().WriteHeader(http.StatusOK)
+				return writeAuditCSV(c.Response(), events)
+			}
+			return c.JSON(http.StatusOK, events)
+		}, requireRole(s, models.RoleAdmin))
 		e.Start(":" + strconv.Itoa(*webPortalPort))
 	case clientCommand.FullCommand():
 		wg := &sync.WaitGroup{}
 		wg.Add(1)
 		c := client.NewClient(*cServerIP, *cServerPort, *cAllowedPortRangeLBound, *cAllowedPortRangeUBound, *cTags, nil)
 		c.FilebrowserDefaultDir = *cFilebrowserDefaultDirectory
+		c.StateDir = *cStateDir
+		c.TLSEnabled = *cTLS
+		c.ServerFingerprint = *cServerFingerprint
+		c.Token = *cToken
+		if _, err := client.ParseGatewayAllowlist(*cGatewayAllow); err != nil {
+			log.Fatal(err)
+		}
+		c.GatewayAllowlist = *cGatewayAllow
+		c.MetricsInterval = *cMetricsInterval
 		c.Start()
 		wg.Wait()
 	}
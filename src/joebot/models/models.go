@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type ClientInfo struct {
 	ID                   string                `json:"id"`
 	IP                   string                `json:"ip"`
@@ -11,6 +13,63 @@ type ClientInfo struct {
 	NovncWebsocketInfo   *NovncWebsocketInfo   `json:"novnc_websocket_info,omitempty"`
 	GottyWebTerminalInfo *GottyWebTerminalInfo `json:"gotty_web_terminal_info,omitempty"`
 	FilebrowserInfo      *FilebrowserInfo      `json:"filebrowser_info,omitempty"`
+	GatewayInfo          *GatewayInfo          `json:"gateway_info,omitempty"`
+	Online               bool                  `json:"online"`
+	// Status is the health of the client derived from its heartbeats
+	Status string `json:"status"`
+	// RTT of the last heartbeat in milliseconds
+	RTT float64 `json:"rtt_ms"`
+	// MissedBeats counts the heartbeats missed in a row
+	MissedBeats   int        `json:"missed_beats"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	// Bytes which went through the tunnels of the client since it connected, and its rate limit in bytes per second
+	BytesReceived uint64 `json:"bytes_received"`
+	BytesSent     uint64 `json:"bytes_sent"`
+	RateLimit     int64  `json:"rate_limit,omitempty"`
+	// Metrics is the last sample of host metrics reported by the client
+	Metrics   *HostMetrics `json:"metrics,omitempty"`
+	FirstSeen time.Time    `json:"first_seen"`
+	LastSeen  time.Time    `json:"last_seen"`
+}
+
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// Data planes carrying the connections of the tunnels
+const (
+	DataPlaneGost  = "gost"
+	DataPlaneYamux = "yamux"
+)
+
+// Status of a client, degraded when heartbeats are missed or slow
+const (
+	ClientOnline   = "online"
+	ClientDegraded = "degraded"
+	ClientOffline  = "offline"
+)
+
+const (
+	ClientConnected    = "connected"
+	ClientDisconnected = "disconnected"
+)
+
+type ConnectionEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	IP   string    `json:"ip"`
+}
+
+// ClientRecord is the persisted form of a client kept in the server registry
+type ClientRecord struct {
+	ID                     string            `json:"id" storm:"id"`
+	Info                   ClientInfo        `json:"info"`
+	History                []ConnectionEvent `json:"history"`
+	CertificateFingerprint string            `json:"certificate_fingerprint,omitempty"`
+	SecretHash             string            `json:"-"`
+	// Tags of the enrollment token, which are always applied to the client
+	EnrollmentTags []string `json:"enrollment_tags,omitempty"`
 }
 
 type ClientCollection struct {
@@ -21,6 +80,123 @@ type PortTunnelInfo struct {
 	GostServerPort int `json:"gost_server_port"`
 	ServerPort     int `json:"server_port"`
 	ClientPort     int `json:"client_port"`
+	// Either tcp or udp, tunnels without protocol are TCP tunnels
+	Protocol string `json:"protocol"`
+	// Server port of the TCP tunnel carrying the datagrams of a UDP tunnel, only reachable from the server itself
+	RelayPort int `json:"relay_port,omitempty"`
+	// Name and Description tell what is behind the client port
+	Name        string    `json:"name,omitempty"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// The tunnel is closed once it expires, or after no traffic went through it for IdleTimeout seconds
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	IdleTimeout  int        `json:"idle_timeout,omitempty"`
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+	// Address the tunnel listens on at the server, all interfaces if empty
+	BindAddress string `json:"bind_address,omitempty"`
+	// Set on both ends of a tunnel between two clients. The listening end accepts connections on localhost:ClientPort
+	// of its client and forwards them to ServerPort, which is the tunnel to PeerPort of the peer client
+	PeerClientID string `json:"peer_client_id,omitempty"`
+	PeerPort     int    `json:"peer_port,omitempty"`
+	Listen       bool   `json:"listen,omitempty"`
+	// Address the listening end connects to from the server, the server port of the peer client or a server-side target of a reverse tunnel
+	TargetAddress string `json:"target_address,omitempty"`
+	// Either gost or yamux, gost if empty. Yamux tunnels carry each connection in a stream of the control session, without gost credential
+	DataPlane string `json:"data_plane,omitempty"`
+	// Bytes received from and sent to the connections to the server port, and the rate limit of each way in bytes per second
+	BytesReceived uint64 `json:"bytes_received"`
+	BytesSent     uint64 `json:"bytes_sent"`
+	RateLimit     int64  `json:"rate_limit,omitempty"`
+	// Credential for the gost tunnel service, only valid for binding ServerPort, or connecting to TargetAddress for listening ends
+	GostUser     string `json:"-"`
+	GostPassword string `json:"-"`
+}
+
+// Scopes of the rate limits
+const (
+	RateLimitClient = "client"
+	RateLimitTag    = "tag"
+)
+
+// RateLimit limits the rate of the tunnels of a client or of the clients having a tag, all together
+type RateLimit struct {
+	ID             string    `json:"id" storm:"id"`
+	Scope          string    `json:"scope"`
+	Target         string    `json:"target"`
+	BytesPerSecond int64     `json:"bytes_per_second"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ExecRequest is a command run on a client without a terminal, the environment variables are given as KEY=VALUE
+type ExecRequest struct {
+	Command string   `json:"command" form:"command"`
+	Args    []string `json:"args" form:"args"`
+	Env     []string `json:"env" form:"env"`
+	Dir     string   `json:"dir" form:"dir"`
+	// Seconds after which the command is killed
+	Timeout int `json:"timeout" form:"timeout"`
+}
+
+const (
+	ExecStdout = "stdout"
+	ExecStderr = "stderr"
+)
+
+// ExecOutput is a chunk of the output of a command run on a client, the last one carries the exit of the command instead
+type ExecOutput struct {
+	Stream string    `json:"stream,omitempty"`
+	Data   string    `json:"data,omitempty"`
+	Exit   *ExecExit `json:"exit,omitempty"`
+}
+
+// ExecExit tells how a command run on a client ended, the exit code is -1 if it was killed or could not be started
+type ExecExit struct {
+	ExitCode int    `json:"exit_code"`
+	TimedOut bool   `json:"timed_out"`
+	Error    string `json:"error,omitempty"`
+	// In seconds
+	Duration float64 `json:"duration"`
+}
+
+// ExecResult is the whole output of a command run on a client
+type ExecResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	ExecExit
+	// Set when the output was longer than what is kept of it
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// PortRangeUsage tells how many ports of a server port range are reserved
+type PortRangeUsage struct {
+	// Set for the range of the clients having the tag
+	Tag   string `json:"tag,omitempty"`
+	Range string `json:"range"`
+	Size  int    `json:"size"`
+	InUse int    `json:"in_use"`
+}
+
+const (
+	PortUsageGost   = "gost"
+	PortUsageTunnel = "tunnel"
+	PortUsageRelay  = "relay"
+	// Reserved for a tunnel being set up
+	PortUsageReserved = "reserved"
+)
+
+// PortLease is a reserved server port along with what it is used for
+type PortLease struct {
+	Port       int    `json:"port"`
+	Usage      string `json:"usage"`
+	ClientID   string `json:"client_id,omitempty"`
+	ClientPort int    `json:"client_port,omitempty"`
+	Protocol   string `json:"protocol,omitempty"`
+	Name       string `json:"name,omitempty"`
+}
+
+type PortUsage struct {
+	Ranges []PortRangeUsage `json:"ranges"`
+	Ports  []PortLease      `json:"ports"`
 }
 
 type NovncWebsocketInfo struct {
@@ -35,11 +211,166 @@ type GottyWebTerminalInfo struct {
 }
 
 type FilebrowserInfo struct {
+	// Path the filebrowser is served under, so that it can be reverse proxied by the web portal
+	BaseURL          string         `json:"base_url"`
 	DefaultDirectory string         `json:"default_directory"`
 	FilebrowserPort  int            `json:"filebrowser_port"`
 	PortTunnelOnHost PortTunnelInfo `json:"port_tunnel"`
 }
 
+// GatewayInfo describes the SOCKS5/HTTP proxy of a client into its network, which only reaches the destinations in the allowlist
+type GatewayInfo struct {
+	GatewayPort      int            `json:"gateway_port"`
+	Allowlist        []string       `json:"allowlist"`
+	PortTunnelOnHost PortTunnelInfo `json:"port_tunnel"`
+}
+
+// HostMetrics is a sample of the resource usage of a client host
+type HostMetrics struct {
+	Time time.Time `json:"time"`
+	// CPUPercent is the usage of all the CPUs since the previous sample
+	CPUPercent float64 `json:"cpu_percent"`
+	CPUCount   int     `json:"cpu_count"`
+	Load1      float64 `json:"load1"`
+	Load5      float64 `json:"load5"`
+	Load15     float64 `json:"load15"`
+	// Memory sizes in bytes
+	MemoryTotal     uint64            `json:"memory_total"`
+	MemoryAvailable uint64            `json:"memory_available"`
+	SwapTotal       uint64            `json:"swap_total"`
+	SwapFree        uint64            `json:"swap_free"`
+	Disks           []DiskUsage       `json:"disks"`
+	Network         []NetworkCounters `json:"network"`
+	// Uptime of the host in seconds
+	Uptime float64 `json:"uptime"`
+}
+
+// DiskUsage is the usage of a mounted filesystem, in bytes
+type DiskUsage struct {
+	Mount       string  `json:"mount"`
+	Device      string  `json:"device"`
+	FSType      string  `json:"fs_type"`
+	Total       uint64  `json:"total"`
+	Used        uint64  `json:"used"`
+	Available   uint64  `json:"available"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// NetworkCounters are the counters of a network interface since the host started
+type NetworkCounters struct {
+	Interface string `json:"interface"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+}
+
+// HostMetricsHistory is the rolling history of the host metrics of a client, oldest first
+type HostMetricsHistory struct {
+	ClientID string        `json:"client_id"`
+	Samples  []HostMetrics `json:"samples"`
+}
+
+// Handshake is sent by the client right after connecting, before the yamux session is set up
+type Handshake struct {
+	ClientID string
+	// Secret issued at enrollment to clients not using TLS
+	Secret string
+	Token  string
+	// PEM encoded certificate signing request, sent by clients over TLS which are not enrolled yet
+	CSR []byte
+	// Tags of the client, known before the tunnels are created as they select the server port range
+	Tags []string
+}
+
+type HandshakeResult struct {
+	ClientID string
+	Accepted bool
+	Message  string
+	// DER encoded certificate issued to the client at enrollment
+	Certificate []byte
+	Secret      string
+	// SSH host key fingerprint of the gost tunnel services, verified by the client when creating tunnels
+	GostHostKeyFingerprint string
+}
+
+// EnrollmentToken authorises new clients to join the server
+type EnrollmentToken struct {
+	ID          string    `json:"id" storm:"id"`
+	TokenHash   string    `json:"-" storm:"unique"`
+	Description string    `json:"description"`
+	SingleUse   bool      `json:"single_use"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Uses        int       `json:"uses"`
+	Revoked     bool      `json:"revoked"`
+	// Only set in the response of the token creation, the token itself is not stored
+	Token string `json:"token,omitempty"`
+}
+
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// User of the web portal. Role applies to every client, whereas TagRoles only grant a role on the clients having the tag
+type User struct {
+	Username     string            `json:"username" storm:"id"`
+	PasswordHash []byte            `json:"-"`
+	Role         string            `json:"role"`
+	TagRoles     map[string]string `json:"tag_roles"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// TerminalRecording is an asciicast v2 recording of a web terminal session
+type TerminalRecording struct {
+	ID        string    `json:"id" storm:"id"`
+	ClientID  string    `json:"client_id" storm:"index"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	Size      int       `json:"size"`
+}
+
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+
+	AuditAccessDenied  = "access.denied"
+	AuditLogin         = "login"
+	AuditClientForget  = "client.forget"
+	AuditTunnelCreate  = "tunnel.create"
+	AuditTunnelClose   = "tunnel.close"
+	AuditTunnelExpire  = "tunnel.expire"
+	AuditRateLimitSet  = "rate_limit.set"
+	AuditTerminalOpen  = "terminal.open"
+	AuditExec          = "exec"
+	AuditFilesOpen     = "files.open"
+	AuditVNCOpen       = "vnc.open"
+	AuditBulkInstall   = "bulk_install"
+	AuditTokenCreate   = "token.create"
+	AuditTokenRevoke   = "token.revoke"
+	AuditUserSave      = "user.save"
+	AuditUserDelete    = "user.delete"
+	AuditRecordingView = "recording.view"
+)
+
+// AuditEvent records an action taken by a user of the web portal
+type AuditEvent struct {
+	ID         int               `json:"id" storm:"id,increment"`
+	Time       time.Time         `json:"time" storm:"index"`
+	Actor      string            `json:"actor" storm:"index"`
+	SourceIP   string            `json:"source_ip"`
+	ClientID   string            `json:"client_id,omitempty" storm:"index"`
+	Action     string            `json:"action" storm:"index"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Outcome    string            `json:"outcome"`
+	Message    string            `json:"message,omitempty"`
+}
+
 type Address struct {
 	IP   string `json:"IP"`
 	Port int    `json:"Port"`
@@ -52,4 +383,5 @@ type BulkInstallInfo struct {
 	Username         string    `json:"Username"`
 	Password         string    `json:"Password"`
 	Key              string    `json:"Key"`
+	Token            string    `json:"Token"`
 }
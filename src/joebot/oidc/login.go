@@ -0,0 +1,75 @@
+package oidc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How long users have to complete the login at the provider
+const loginTimeout = 10 * time.Minute
+
+type pendingLogin struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// LoginFlow keeps track of the logins in progress, keyed by their state parameter
+type LoginFlow struct {
+	Provider *Provider
+
+	pending map[string]pendingLogin
+	lock    sync.Mutex
+}
+
+func NewLoginFlow(provider *Provider) *LoginFlow {
+	return &LoginFlow{Provider: provider, pending: map[string]pendingLogin{}}
+}
+
+// Start returns the state of the new login, and the URL of the provider to redirect the user to
+func (flow *LoginFlow) Start(redirectURL string) (string, string, error) {
+	state, err := NewRandomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := NewRandomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := NewRandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	flow.lock.Lock()
+	defer flow.lock.Unlock()
+
+	now := time.Now()
+	for s, login := range flow.pending {
+		if now.After(login.expiresAt) {
+			delete(flow.pending, s)
+		}
+	}
+	flow.pending[state] = pendingLogin{nonce: nonce, verifier: verifier, expiresAt: now.Add(loginTimeout)}
+
+	return state, flow.Provider.AuthCodeURL(redirectURL, state, nonce, verifier), nil
+}
+
+// Finish redeems the authorization code returned to the callback, each state can only be used once
+func (flow *LoginFlow) Finish(redirectURL string, state string, code string) (Claims, error) {
+	flow.lock.Lock()
+	login, ok := flow.pending[state]
+	delete(flow.pending, state)
+	flow.lock.Unlock()
+
+	if !ok || time.Now().After(login.expiresAt) {
+		return nil, errors.New("Unknown or expired login state")
+	}
+	idToken, err := flow.Provider.Exchange(redirectURL, code, login.verifier)
+	if err != nil {
+		return nil, err
+	}
+	return flow.Provider.VerifyIDToken(idToken, login.nonce)
+}
@@ -0,0 +1,347 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Allowed difference between the clocks of the provider and the server
+const clockSkew = time.Minute
+
+// Provider implements the authorization code flow with PKCE against an OpenID Connect provider
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+	EndSessionEndpoint    string
+
+	httpClient    *http.Client
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+	keysLock      sync.Mutex
+}
+
+// NewProvider fetches the discovery document of the issuer
+func NewProvider(issuer string, clientID string, clientSecret string) (*Provider, error) {
+	provider := &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"openid", "profile", "email", "groups"},
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		keys:         map[string]crypto.PublicKey{},
+	}
+
+	discovery := struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+		EndSessionEndpoint    string `json:"end_session_endpoint"`
+	}{}
+	if err := provider.getJSON(provider.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, errors.Wrap(err, "Failed To Fetch OIDC Discovery Document")
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != provider.Issuer {
+		return nil, errors.New("OIDC discovery document is issued for another issuer: " + discovery.Issuer)
+	}
+	provider.AuthorizationEndpoint = discovery.AuthorizationEndpoint
+	provider.TokenEndpoint = discovery.TokenEndpoint
+	provider.JWKSURI = discovery.JWKSURI
+	provider.EndSessionEndpoint = discovery.EndSessionEndpoint
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing required endpoints")
+	}
+
+	return provider, nil
+}
+
+func (provider *Provider) getJSON(url string, result interface{}) error {
+	resp, err := provider.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Unexpected status " + resp.Status + " from " + url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// NewRandomString returns a URL safe random string, used for states, nonces and PKCE verifiers
+func NewRandomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CodeChallenge derives the S256 PKCE challenge of a verifier
+func CodeChallenge(verifier string) string {
+	digest := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// AuthCodeURL is where users are redirected to log in
+func (provider *Provider) AuthCodeURL(redirectURL string, state string, nonce string, verifier string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(provider.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades the authorization code for the ID token
+func (provider *Provider) Exchange(redirectURL string, code string, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {provider.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if provider.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(provider.ClientSecret))
+	}
+
+	resp, err := provider.httpClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed To Exchange Authorization Code")
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", errors.Wrap(err, "Failed To Decode Token Response")
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return "", errors.New("Token endpoint rejected the authorization code: " + result.Error + " " + result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return "", errors.New("Token response does not contain an ID token")
+	}
+	return result.IDToken, nil
+}
+
+// Claims of a verified ID token
+type Claims map[string]interface{}
+
+func (claims Claims) String(name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// Strings reads a claim holding either a list of strings or a single string
+func (claims Claims) Strings(name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		result := []string{}
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return []string{}
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token
+func (provider *Provider) VerifyIDToken(rawToken string, nonce string) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed ID token")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.Wrap(err, "Malformed ID token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "Malformed ID token signature")
+	}
+	key, err := provider.publicKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := Claims{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.Wrap(err, "Malformed ID token claims")
+	}
+	if strings.TrimSuffix(claims.String("iss"), "/") != provider.Issuer {
+		return nil, errors.New("ID token is issued by another issuer")
+	}
+	audiences := claims.Strings("aud")
+	audienceFound := false
+	for _, audience := range audiences {
+		audienceFound = audienceFound || audience == provider.ClientID
+	}
+	if !audienceFound {
+		return nil, errors.New("ID token is issued for another client")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Now().Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token has expired")
+	}
+	if claims.String("nonce") != nonce {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, result interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("ID token algorithm does not match the signing key")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("Invalid ID token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("ID token algorithm does not match the signing key")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return errors.New("Invalid ID token signature")
+		}
+	default:
+		return errors.New("Unsupported ID token algorithm: " + alg)
+	}
+	return nil
+}
+
+// publicKey looks up a signing key, the key set is refetched when the key is unknown to support key rotation
+func (provider *Provider) publicKey(kid string) (crypto.PublicKey, error) {
+	provider.keysLock.Lock()
+	defer provider.keysLock.Unlock()
+
+	if key, ok := provider.keys[kid]; ok {
+		return key, nil
+	}
+	// Tokens with bogus key IDs must not hammer the provider
+	if time.Since(provider.keysFetchedAt) > 10*time.Second {
+		if err := provider.fetchKeys(); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := provider.keys[kid]; ok {
+		return key, nil
+	}
+	// Providers with a single key may not set the key ID
+	if kid == "" && len(provider.keys) == 1 {
+		for _, key := range provider.keys {
+			return key, nil
+		}
+	}
+	return nil, errors.New("Unknown ID token signing key: " + kid)
+}
+
+func (provider *Provider) fetchKeys() error {
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}{}
+	if err := provider.getJSON(provider.JWKSURI, &jwks); err != nil {
+		return errors.Wrap(err, "Failed To Fetch OIDC Signing Keys")
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil || jwk.Crv != "P-256" {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	provider.keys = keys
+	provider.keysFetchedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,201 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a minimal OpenID Connect provider, which authorizes every login request
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// Claims overridden in the issued ID tokens
+	overrides map[string]interface{}
+
+	challenge string
+	nonce     string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{key: key, overrides: map[string]interface{}{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+			"end_session_endpoint":   issuer.server.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		issuer.challenge = query.Get("code_challenge")
+		issuer.nonce = query.Get("nonce")
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code=test-code&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "test-code" || CodeChallenge(r.Form.Get("code_verifier")) != issuer.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.sign(t)})
+	})
+	issuer.server = httptest.NewServer(mux)
+	return issuer
+}
+
+func (issuer *mockIssuer) sign(t *testing.T) string {
+	claims := map[string]interface{}{
+		"iss":                issuer.server.URL,
+		"aud":                "joebot",
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"groups":             []string{"team-a"},
+		"nonce":              issuer.nonce,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range issuer.overrides {
+		claims[name] = value
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, issuer.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login runs the whole flow, following the redirect of the provider to the callback
+func login(t *testing.T, flow *LoginFlow) (Claims, error) {
+	redirectURL := "http://joebot.test/auth/callback"
+	_, authURL, err := flow.Start(redirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return flow.Finish(redirectURL, callback.Query().Get("state"), callback.Query().Get("code"))
+}
+
+func TestLoginFlow(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.server.Close()
+
+	provider, err := NewProvider(issuer.server.URL, "joebot", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.EndSessionEndpoint != issuer.server.URL+"/logout" {
+		t.Errorf("unexpected end session endpoint: %s", provider.EndSessionEndpoint)
+	}
+
+	claims, err := login(t, NewLoginFlow(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.String("preferred_username") != "alice" {
+		t.Errorf("unexpected username: %s", claims.String("preferred_username"))
+	}
+	if groups := claims.Strings("groups"); len(groups) != 1 || groups[0] != "team-a" {
+		t.Errorf("unexpected groups: %v", groups)
+	}
+}
+
+func TestLoginFlowRejectsInvalidTokens(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.server.Close()
+
+	provider, err := NewProvider(issuer.server.URL, "joebot", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]map[string]interface{}{
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+		"wrong audience": {"aud": "another-client"},
+		"wrong issuer":   {"iss": "https://evil.test"},
+		"wrong nonce":    {"nonce": "replayed"},
+	}
+	for name, overrides := range cases {
+		issuer.overrides = overrides
+		if _, err := login(t, NewLoginFlow(provider)); err == nil {
+			t.Errorf("%s: ID token was accepted", name)
+		}
+	}
+}
+
+func TestLoginFlowRejectsUnknownState(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.server.Close()
+
+	provider, err := NewProvider(issuer.server.URL, "joebot", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := NewLoginFlow(provider)
+	if _, err = flow.Finish("http://joebot.test/auth/callback", "unknown", "test-code"); err == nil {
+		t.Error("unknown state was accepted")
+	}
+}
+
+func TestVerifyIDTokenRejectsTamperedSignature(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.server.Close()
+
+	provider, err := NewProvider(issuer.server.URL, "joebot", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.nonce = "nonce"
+	token := issuer.sign(t)
+	tampered := token[:len(token)-4] + "AAAA"
+	if _, err = provider.VerifyIDToken(tampered, "nonce"); err == nil {
+		t.Error("tampered ID token was accepted")
+	}
+	if _, err = provider.VerifyIDToken(token, "nonce"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/pkg/errors"
+)
+
+// AuditLog is an append-only log of the actions taken on the web portal, kept in the registry database
+type AuditLog struct {
+	db storm.Node
+}
+
+func NewAuditLog(registry *Registry) *AuditLog {
+	return &AuditLog{db: registry.db.From("audit")}
+}
+
+func (audit *AuditLog) Append(event models.AuditEvent) error {
+	event.ID = 0
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	return errors.Wrap(audit.db.Save(&event), "Failed To Save Audit Event")
+}
+
+// AuditFilter selects audit events, zero values match everything
+type AuditFilter struct {
+	Since    time.Time
+	Until    time.Time
+	Actor    string
+	ClientID string
+	Action   string
+}
+
+// Query returns the matching events, oldest first
+func (audit *AuditLog) Query(filter AuditFilter) ([]models.AuditEvent, error) {
+	matchers := []q.Matcher{}
+	if !filter.Since.IsZero() {
+		matchers = append(matchers, q.Gte("Time", filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		matchers = append(matchers, q.Lte("Time", filter.Until))
+	}
+	if filter.Actor != "" {
+		matchers = append(matchers, q.Eq("Actor", filter.Actor))
+	}
+	if filter.ClientID != "" {
+		matchers = append(matchers, q.Eq("ClientID", filter.ClientID))
+	}
+	if filter.Action != "" {
+		matchers = append(matchers, q.Eq("Action", filter.Action))
+	}
+
+	events := []models.AuditEvent{}
+	err := audit.db.Select(matchers...).OrderBy("ID").Find(&events)
+	if err == storm.ErrNotFound {
+		return events, nil
+	}
+	return events, err
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+// TunnelTraffic is the number of bytes received from and sent to the connections accepted on server ports
+type TunnelTraffic struct {
+	Received uint64
+	Sent     uint64
+}
+
+// Bandwidth counts the bytes of the connections of a tunnel, a client or a tag, and limits their rate each way
+type Bandwidth struct {
+	// Updated atomically, first for the alignment of atomic operations
+	traffic TunnelTraffic
+
+	receiveLimiter *utils.RateLimiter
+	sendLimiter    *utils.RateLimiter
+}
+
+func NewBandwidth(bytesPerSecond int64) *Bandwidth {
+	return &Bandwidth{receiveLimiter: utils.NewRateLimiter(bytesPerSecond), sendLimiter: utils.NewRateLimiter(bytesPerSecond)}
+}
+
+// SetLimit limits the rate of each way, zero means unlimited
+func (b *Bandwidth) SetLimit(bytesPerSecond int64) {
+	b.receiveLimiter.SetRate(bytesPerSecond)
+	b.sendLimiter.SetRate(bytesPerSecond)
+}
+
+func (b *Bandwidth) Limit() int64 {
+	return b.receiveLimiter.Rate()
+}
+
+func (b *Bandwidth) Traffic() TunnelTraffic {
+	return TunnelTraffic{Received: atomic.LoadUint64(&b.traffic.Received), Sent: atomic.LoadUint64(&b.traffic.Sent)}
+}
+
+// received counts the bytes read from a connection, and waits for the rate to let them through
+func (b *Bandwidth) received(n int) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddUint64(&b.traffic.Received, uint64(n))
+	b.receiveLimiter.Wait(n)
+}
+
+// sending waits for the rate to let the bytes through before they are written to a connection
+func (b *Bandwidth) sending(n int) {
+	b.sendLimiter.Wait(n)
+}
+
+func (b *Bandwidth) sent(n int) {
+	if n > 0 {
+		atomic.AddUint64(&b.traffic.Sent, uint64(n))
+	}
+}
+
+func rateLimitID(scope string, target string) string {
+	return scope + ":" + target
+}
+
+// loadRateLimits restores the rate limits of the tags and clients from the registry
+func (server *Server) loadRateLimits() error {
+	server.bandwidthLock.Lock()
+	defer server.bandwidthLock.Unlock()
+
+	limits := []models.RateLimit{}
+	if err := server.rateLimitsDB.All(&limits); err != nil && err != storm.ErrNotFound {
+		return errors.Wrap(err, "Failed To Load Rate Limits")
+	}
+	for _, limit := range limits {
+		server.rateLimits[limit.ID] = limit
+		if limit.Scope == models.RateLimitTag {
+			server.tagBandwidths[limit.Target] = NewBandwidth(limit.BytesPerSecond)
+		}
+	}
+	return nil
+}
+
+// setRateLimit stores the rate limit of the client or tag, which is removed when zero
+func (server *Server) setRateLimit(scope string, target string, bytesPerSecond int64) (models.RateLimit, error) {
+	limit := models.RateLimit{ID: rateLimitID(scope, target), Scope: scope, Target: target, BytesPerSecond: bytesPerSecond, UpdatedAt: time.Now()}
+	if bytesPerSecond < 0 {
+		return limit, errors.New("Rate Limit Must Not Be Negative")
+	}
+
+	if server.rateLimitsDB != nil {
+		var err error
+		if bytesPerSecond == 0 {
+			if err = server.rateLimitsDB.DeleteStruct(&models.RateLimit{ID: limit.ID}); err == storm.ErrNotFound {
+				err = nil
+			}
+		} else {
+			err = server.rateLimitsDB.Save(&limit)
+		}
+		if err != nil {
+			return limit, errors.Wrap(err, "Failed To Save Rate Limit")
+		}
+	}
+	if bytesPerSecond == 0 {
+		delete(server.rateLimits, limit.ID)
+	} else {
+		server.rateLimits[limit.ID] = limit
+	}
+	return limit, nil
+}
+
+// SetClientRateLimit limits the rate of all the tunnels of the client together, the limit is kept while the client is offline
+func (server *Server) SetClientRateLimit(clientID string, bytesPerSecond int64) (models.RateLimit, error) {
+	server.bandwidthLock.Lock()
+	defer server.bandwidthLock.Unlock()
+
+	limit, err := server.setRateLimit(models.RateLimitClient, clientID, bytesPerSecond)
+	if err != nil {
+		return limit, err
+	}
+	if client, err := server.GetClientById(clientID); err == nil {
+		client.bandwidth.SetLimit(bytesPerSecond)
+	}
+	return limit, nil
+}
+
+// SetTagRateLimit limits the rate of all the tunnels of the clients having the tag together
+func (server *Server) SetTagRateLimit(tag string, bytesPerSecond int64) (models.RateLimit, error) {
+	server.bandwidthLock.Lock()
+	defer server.bandwidthLock.Unlock()
+
+	limit, err := server.setRateLimit(models.RateLimitTag, tag, bytesPerSecond)
+	if err != nil {
+		return limit, err
+	}
+	if bandwidth, ok := server.tagBandwidths[tag]; ok {
+		bandwidth.SetLimit(bytesPerSecond)
+	} else if bytesPerSecond > 0 {
+		server.tagBandwidths[tag] = NewBandwidth(bytesPerSecond)
+	}
+	return limit, nil
+}
+
+// RateLimits lists the rate limits of the clients and tags
+func (server *Server) RateLimits() []models.RateLimit {
+	server.bandwidthLock.Lock()
+	defer server.bandwidthLock.Unlock()
+
+	limits := []models.RateLimit{}
+	for _, limit := range server.rateLimits {
+		limits = append(limits, limit)
+	}
+	sort.Slice(limits, func(i, j int) bool { return limits[i].ID < limits[j].ID })
+	return limits
+}
+
+// clientRateLimit returns the rate limit of the client, zero if none
+func (server *Server) clientRateLimit(clientID string) int64 {
+	server.bandwidthLock.Lock()
+	defer server.bandwidthLock.Unlock()
+
+	return server.rateLimits[rateLimitID(models.RateLimitClient, clientID)].BytesPerSecond
+}
+
+// accountsOf returns the bandwidths of the client owning the server port, and of its tags having a rate limit
+func (server *Server) accountsOf(serverPort int) []*Bandwidth {
+	owner, ok := server.portsManager.Owner(serverPort)
+	if !ok {
+		return nil
+	}
+	client, err := server.GetClientById(owner)
+	if err != nil {
+		return nil
+	}
+	tags := client.tags()
+
+	server.bandwidthLock.Lock()
+	defer server.bandwidthLock.Unlock()
+
+	accounts := []*Bandwidth{client.bandwidth}
+	for _, tag := range tags {
+		if bandwidth, ok := server.tagBandwidths[tag]; ok {
+			accounts = append(accounts, bandwidth)
+		}
+	}
+	return accounts
+}
+
+// SetTunnelRateLimit limits the rate of the tunnel of the client port, zero means unlimited
+func (client *Client) SetTunnelRateLimit(clientPort int, protocol string, bytesPerSecond int64) (models.PortTunnelInfo, error) {
+	if bytesPerSecond < 0 {
+		return models.PortTunnelInfo{}, errors.New("Rate Limit Must Not Be Negative")
+	}
+	protocol = tunnelProtocol(protocol)
+	var tunnel *models.PortTunnelInfo
+	client.updateInfo(func(info *models.ClientInfo) {
+		for i, t := range info.PortTunnels {
+			if t.ClientPort == clientPort && tunnelProtocol(t.Protocol) == protocol && t.PeerClientID == "" {
+				info.PortTunnels[i].RateLimit = bytesPerSecond
+				updated := info.PortTunnels[i]
+				tunnel = &updated
+				return
+			}
+		}
+	})
+	if tunnel == nil {
+		return models.PortTunnelInfo{}, errors.Errorf("No %s tunnel to client port %d", protocol, clientPort)
+	}
+	client.applyTunnelRateLimit(*tunnel)
+	return client.GetTunnel(clientPort, protocol)
+}
+
+// applyTunnelRateLimit sets the rate limit of the tunnel on its server ports
+func (client *Client) applyTunnelRateLimit(tunnel models.PortTunnelInfo) {
+	if tunnel.Listen {
+		return
+	}
+	client.server.tunnelActivity.Bandwidth(tunnel.ServerPort).SetLimit(tunnel.RateLimit)
+	if tunnel.RelayPort > 0 {
+		client.server.tunnelActivity.Bandwidth(tunnel.RelayPort).SetLimit(tunnel.RateLimit)
+	}
+}
+
+// tunnelTraffic returns the bytes which went through the server ports of the tunnel
+func (client *Client) tunnelTraffic(tunnel models.PortTunnelInfo) TunnelTraffic {
+	if tunnel.Listen {
+		return TunnelTraffic{}
+	}
+	traffic := client.server.tunnelActivity.Traffic(tunnel.ServerPort)
+	if tunnel.RelayPort > 0 {
+		relay := client.server.tunnelActivity.Traffic(tunnel.RelayPort)
+		traffic.Received += relay.Received
+		traffic.Sent += relay.Sent
+	}
+	return traffic
+}
+
+// previousTunnelRateLimit returns the rate limit the tunnel had in the previous session of the client.
+// The client ports of the services may change between sessions, so named tunnels are matched by name
+func (client *Client) previousTunnelRateLimit(tunnel models.PortTunnelInfo) int64 {
+	if client.previousInfo == nil {
+		return 0
+	}
+	for _, t := range client.previousInfo.PortTunnels {
+		if tunnelProtocol(t.Protocol) != tunnel.Protocol || t.Listen || t.PeerClientID != tunnel.PeerClientID {
+			continue
+		}
+		if t.ClientPort == tunnel.ClientPort || (tunnel.Name != "" && t.Name == tunnel.Name) {
+			return t.RateLimit
+		}
+	}
+	return 0
+}
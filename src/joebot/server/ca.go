@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+// CertificateAuthority issues the certificates used on the control channel.
+// The CA is persisted in the data directory, whereas the server certificate is re-issued on every start
+type CertificateAuthority struct {
+	cert    *x509.Certificate
+	certDER []byte
+	key     *ecdsa.PrivateKey
+
+	serverCert tls.Certificate
+}
+
+func LoadOrCreateCertificateAuthority(dir string) (*CertificateAuthority, error) {
+	ca := &CertificateAuthority{}
+	certPath := filepath.Join(dir, "ca.crt")
+	keyPath := filepath.Join(dir, "ca.key")
+
+	if utils.IsFileExist(certPath) && utils.IsFileExist(keyPath) {
+		certPEM, err := ioutil.ReadFile(certPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed To Read CA Certificate")
+		}
+		keyPEM, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed To Read CA Key")
+		}
+		if ca.cert, err = utils.ParseCertificatePEM(certPEM); err != nil {
+			return nil, errors.Wrap(err, "Failed To Parse CA Certificate")
+		}
+		if ca.key, err = utils.ParsePrivateKeyPEM(keyPEM); err != nil {
+			return nil, errors.Wrap(err, "Failed To Parse CA Key")
+		}
+		ca.certDER = ca.cert.Raw
+	} else if err := ca.generate(certPath, keyPath); err != nil {
+		return nil, err
+	}
+
+	if err := ca.issueServerCertificate(); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+func (ca *CertificateAuthority) generate(certPath string, keyPath string) error {
+	var err error
+	if ca.key, err = utils.GeneratePrivateKey(); err != nil {
+		return errors.Wrap(err, "Failed To Generate CA Key")
+	}
+	serial, err := utils.GenerateSerialNumber()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Joebot"}, CommonName: "Joebot CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(20, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if ca.certDER, err = x509.CreateCertificate(rand.Reader, template, template, &ca.key.PublicKey, ca.key); err != nil {
+		return errors.Wrap(err, "Failed To Create CA Certificate")
+	}
+	if ca.cert, err = x509.ParseCertificate(ca.certDER); err != nil {
+		return err
+	}
+
+	keyPEM, err := utils.EncodePrivateKeyPEM(ca.key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return errors.Wrap(err, "Failed To Save CA Key")
+	}
+	return errors.Wrap(ioutil.WriteFile(certPath, utils.EncodeCertificatePEM(ca.certDER), 0644), "Failed To Save CA Certificate")
+}
+
+func (ca *CertificateAuthority) issueServerCertificate() error {
+	key, err := utils.GeneratePrivateKey()
+	if err != nil {
+		return err
+	}
+	serial, err := utils.GenerateSerialNumber()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Joebot"}, CommonName: "Joebot Server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return errors.Wrap(err, "Failed To Create Server Certificate")
+	}
+
+	// The CA is sent along with the server certificate so that clients are able to pin it on first use
+	ca.serverCert = tls.Certificate{Certificate: [][]byte{der, ca.certDER}, PrivateKey: key}
+	return nil
+}
+
+// IssueClientCertificate signs the CSR of a client, the common name is always set to the client ID
+func (ca *CertificateAuthority) IssueClientCertificate(clientID string, csrPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("Invalid PEM encoded certificate signing request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed To Parse Certificate Signing Request")
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, errors.Wrap(err, "Invalid Certificate Signing Request Signature")
+	}
+	serial, err := utils.GenerateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Joebot"}, CommonName: clientID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed To Create Client Certificate")
+	}
+	return der, nil
+}
+
+func (ca *CertificateAuthority) CertificatePEM() []byte {
+	return utils.EncodeCertificatePEM(ca.certDER)
+}
+
+// Fingerprint of the CA certificate, which clients may pin via --server-fingerprint
+func (ca *CertificateAuthority) Fingerprint() string {
+	return utils.CertificateFingerprint(ca.certDER)
+}
+
+// TLSConfig accepts clients without certificate for enrollment, but rejects certificates not issued by the CA
+func (ca *CertificateAuthority) TLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{ca.serverCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"context"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/harmonicinc-com/joebot/utils"
@@ -27,7 +29,26 @@ type Client struct {
 	ctx  context.Context
 	stop context.CancelFunc
 
-	Info models.ClientInfo
+	// Info is read by the API while the tunnels of the client are created, closed and expired, infoLock guards it
+	Info     models.ClientInfo
+	infoLock sync.Mutex
+	// Info of the previous session, used for re-creating tunnels on the same server ports
+	previousInfo *models.ClientInfo
+	// Tags of the token used at enrollment, merged with the tags reported by the client
+	enrollmentTags []string
+	// Relays of the UDP tunnels by server port
+	udpRelays sync.Map
+	// Listeners of the tunnels on the yamux data plane by server port
+	streamTunnels sync.Map
+	// Held while a tunnel is created, so that a client port is not tunnelled twice by concurrent requests
+	tunnelLock sync.Mutex
+
+	// Bytes going through all the tunnels of the client, along with its rate limit
+	bandwidth *Bandwidth
+
+	// Rolling history of the host metrics reported by the client
+	hostMetrics     []models.HostMetrics
+	hostMetricsLock sync.Mutex
 }
 
 func NewClient(id string, server *Server, conn *net.Conn, logger *logrus.Logger) *Client {
@@ -47,17 +68,67 @@ func NewClient(id string, server *Server, conn *net.Conn, logger *logrus.Logger)
 	client.Info.Tags = []string{}
 	client.Info.PortTunnels = []models.PortTunnelInfo{}
 
+	client.bandwidth = NewBandwidth(0)
+	if server != nil {
+		client.bandwidth.SetLimit(server.clientRateLimit(id))
+	}
+
 	logger.Info("Init new client")
 	return client
 }
 
 func (client *Client) UpdateInfo(info models.ClientInfo) {
-	if info.Tags != nil {
-		client.Info.Tags = info.Tags
+	client.updateInfo(func(clientInfo *models.ClientInfo) {
+		client.setTagsLocked(info.Tags)
+		clientInfo.IP = info.IP
+		clientInfo.HostName = info.HostName
+		clientInfo.Username = info.Username
+	})
+}
+
+// setTags sets the tags reported by the client, along with the tags of its enrollment token
+func (client *Client) setTags(tags []string) {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+
+	client.setTagsLocked(tags)
+}
+
+func (client *Client) setTagsLocked(tags []string) {
+	if tags != nil {
+		client.Info.Tags = tags
+	}
+	for _, tag := range client.enrollmentTags {
+		if !utils.Contains(client.Info.Tags, tag) {
+			client.Info.Tags = append(client.Info.Tags, tag)
+		}
+	}
+}
+
+// updateInfo changes the client info under infoLock, then persists it
+func (client *Client) updateInfo(update func(info *models.ClientInfo)) {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+
+	update(&client.Info)
+	client.saveInfoLocked()
+}
+
+// saveInfo persists the client info into the server registry, if any
+func (client *Client) saveInfo() {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+
+	client.saveInfoLocked()
+}
+
+func (client *Client) saveInfoLocked() {
+	if client.server == nil || client.server.registry == nil {
+		return
+	}
+	if err := client.server.registry.SaveInfo(client.Info); err != nil {
+		client.logger.WithField("Client ID", client.ID).Error(errors.Wrap(err, "Failed To Save Client Info"))
 	}
-	client.Info.IP = info.IP
-	client.Info.HostName = info.HostName
-	client.Info.Username = info.Username
 }
 
 func (client *Client) ExitIfError(err error, message string) bool {
@@ -89,7 +160,11 @@ func (client *Client) Start() {
 		client.ExitIfError(err, "")
 	})
 	inHandler.RegisterTask(NewClientInfoUpdateTask(client))
+	inHandler.RegisterTask(NewTerminalRecordingTask(client))
+	inHandler.RegisterTask(NewTunnelDialTask(client))
+	inHandler.RegisterTask(NewHostMetricsReportTask(client))
 	inHandler.Start()
+	go client.heartbeat()
 
 	if _, err := client.CreateSSHTunnel(); err != nil {
 		client.logger.Info(errors.Wrap(err, "Failed To Create SSH Tunnel"))
@@ -103,12 +178,15 @@ func (client *Client) Start() {
 	if _, err = client.CreateFilebrowser(); err != nil {
 		client.logger.Info(errors.Wrap(err, "Failed To Create Web filebrowser"))
 	}
+	if _, err = client.CreateGateway(); err != nil {
+		client.logger.Info(errors.Wrap(err, "Failed To Create Gateway"))
+	}
 }
 
 func (client *Client) CreateSSHTunnel() (models.PortTunnelInfo, error) {
 	var err error
 	var sshTunnel models.PortTunnelInfo
-	if client.Info.SSHTunnel != nil {
+	if client.copyInfo().SSHTunnel != nil {
 		return sshTunnel, errors.New("Failed to create SSH tunnel | tunnel already exists")
 	}
 
@@ -126,24 +204,29 @@ func (client *Client) CreateSSHTunnel() (models.PortTunnelInfo, error) {
 		return sshTunnel, err
 	}
 
-	portTunnelInfo, err := client.CreateTunnel(sshTunnel.ClientPort)
+	preferredServerPort := 0
+	if client.previousInfo != nil && client.previousInfo.SSHTunnel != nil {
+		preferredServerPort = client.previousInfo.SSHTunnel.ServerPort
+	}
+	portTunnelInfo, err := client.createTunnel(sshTunnel.ClientPort, preferredServerPort, TunnelOptions{Name: "ssh", Description: "SSH server"})
 	if err != nil {
 		return sshTunnel, errors.Wrap(err, "Failed To Create SSH Tunnel")
 	}
 
-	client.Info.SSHTunnel = &portTunnelInfo
+	client.updateInfo(func(info *models.ClientInfo) { info.SSHTunnel = &portTunnelInfo })
 	return sshTunnel, err
 }
 
 func (client *Client) CreateFilebrowser() (models.FilebrowserInfo, error) {
 	var err error
 	var fbInfo models.FilebrowserInfo
-	if client.Info.FilebrowserInfo != nil {
+	if client.copyInfo().FilebrowserInfo != nil {
 		return fbInfo, errors.New("Failed to create web filebrowser | service already exists")
 	}
 
 	client.logger.WithField("Client ID", client.ID).Info("Creating web filebrowser")
-	stream, err := task.NewTask(client.ctx, task.FilebrowserRequest, client.logger).Request(client.session, []byte{})
+	fbInfo.BaseURL = "/c/" + client.ID + "/files"
+	stream, err := task.NewTask(client.ctx, task.FilebrowserRequest, client.logger).Request(client.session, utils.StructToBytes(fbInfo))
 	if err != nil {
 		return fbInfo, err
 	}
@@ -156,20 +239,64 @@ func (client *Client) CreateFilebrowser() (models.FilebrowserInfo, error) {
 		return fbInfo, err
 	}
 
-	portTunnelInfo, err := client.CreateTunnel(fbInfo.FilebrowserPort)
+	preferredServerPort := 0
+	if client.previousInfo != nil && client.previousInfo.FilebrowserInfo != nil {
+		preferredServerPort = client.previousInfo.FilebrowserInfo.PortTunnelOnHost.ServerPort
+	}
+	portTunnelInfo, err := client.createTunnel(fbInfo.FilebrowserPort, preferredServerPort, TunnelOptions{Name: "files", Description: "Web file manager"})
 	if err != nil {
 		return fbInfo, errors.Wrap(err, "Failed To Create Tunnel To Web filebrowser")
 	}
 	fbInfo.PortTunnelOnHost = portTunnelInfo
 
-	client.Info.FilebrowserInfo = &fbInfo
+	client.updateInfo(func(info *models.ClientInfo) { info.FilebrowserInfo = &fbInfo })
 	return fbInfo, nil
 }
 
+// CreateGateway tunnels the SOCKS5/HTTP gateway of the client, which is only started if the client allows some destinations
+func (client *Client) CreateGateway() (models.GatewayInfo, error) {
+	var err error
+	var gatewayInfo models.GatewayInfo
+	if client.copyInfo().GatewayInfo != nil {
+		return gatewayInfo, errors.New("Failed to create gateway | service already exists")
+	}
+
+	client.logger.WithField("Client ID", client.ID).Info("Creating gateway")
+	stream, err := task.NewTask(client.ctx, task.GatewayRequest, client.logger).Request(client.session, []byte{})
+	if err != nil {
+		return gatewayInfo, err
+	}
+
+	body, err := task.ReceiveObject(stream, time.Second*10)
+	if err != nil {
+		return gatewayInfo, errors.Wrap(err, "Failed To Receive Gateway Info From Client")
+	}
+	if err = utils.BytesToStruct(body, &gatewayInfo); err != nil {
+		return gatewayInfo, err
+	}
+	if gatewayInfo.GatewayPort == 0 {
+		client.logger.WithField("Client ID", client.ID).Info("Gateway disabled by client")
+		return gatewayInfo, nil
+	}
+
+	preferredServerPort := 0
+	if client.previousInfo != nil && client.previousInfo.GatewayInfo != nil {
+		preferredServerPort = client.previousInfo.GatewayInfo.PortTunnelOnHost.ServerPort
+	}
+	portTunnelInfo, err := client.createTunnel(gatewayInfo.GatewayPort, preferredServerPort, TunnelOptions{Name: "gateway", Description: "SOCKS5/HTTP proxy into the client network"})
+	if err != nil {
+		return gatewayInfo, errors.Wrap(err, "Failed To Create Tunnel To Gateway")
+	}
+	gatewayInfo.PortTunnelOnHost = portTunnelInfo
+
+	client.updateInfo(func(info *models.ClientInfo) { info.GatewayInfo = &gatewayInfo })
+	return gatewayInfo, nil
+}
+
 func (client *Client) CreateGottyWebTerminal() (models.GottyWebTerminalInfo, error) {
 	var err error
 	var wtInfo models.GottyWebTerminalInfo
-	if client.Info.GottyWebTerminalInfo != nil {
+	if client.copyInfo().GottyWebTerminalInfo != nil {
 		return wtInfo, errors.New("Failed to create Gotty web terminal tunnel | service already exists")
 	}
 
@@ -187,20 +314,24 @@ func (client *Client) CreateGottyWebTerminal() (models.GottyWebTerminalInfo, err
 		return wtInfo, err
 	}
 
-	portTunnelInfo, err := client.CreateTunnel(wtInfo.GottyWebTerminalPort)
+	preferredServerPort := 0
+	if client.previousInfo != nil && client.previousInfo.GottyWebTerminalInfo != nil {
+		preferredServerPort = client.previousInfo.GottyWebTerminalInfo.PortTunnelOnHost.ServerPort
+	}
+	portTunnelInfo, err := client.createTunnel(wtInfo.GottyWebTerminalPort, preferredServerPort, TunnelOptions{Name: "terminal", Description: "Web terminal"})
 	if err != nil {
 		return wtInfo, errors.Wrap(err, "Failed To Create Tunnel To Gotty Web Terminal")
 	}
 	wtInfo.PortTunnelOnHost = portTunnelInfo
 
-	client.Info.GottyWebTerminalInfo = &wtInfo
+	client.updateInfo(func(info *models.ClientInfo) { info.GottyWebTerminalInfo = &wtInfo })
 	return wtInfo, nil
 }
 
 func (client *Client) CreateNovncWebsocketTunnel(clientVncPort int) (models.NovncWebsocketInfo, error) {
 	var err error
 	var novncWebsocketInfo models.NovncWebsocketInfo
-	if client.Info.NovncWebsocketInfo != nil {
+	if client.copyInfo().NovncWebsocketInfo != nil {
 		return novncWebsocketInfo, errors.New("Failed to create novnc tunnel | service already exists")
 	}
 
@@ -221,62 +352,528 @@ func (client *Client) CreateNovncWebsocketTunnel(clientVncPort int) (models.Novn
 		return novncWebsocketInfo, err
 	}
 
-	portTunnelInfo, err := client.CreateTunnel(novncWebsocketInfo.NovncWebsocketPort)
+	preferredServerPort := 0
+	if client.previousInfo != nil && client.previousInfo.NovncWebsocketInfo != nil {
+		preferredServerPort = client.previousInfo.NovncWebsocketInfo.PortTunnelOnHost.ServerPort
+	}
+	portTunnelInfo, err := client.createTunnel(novncWebsocketInfo.NovncWebsocketPort, preferredServerPort, TunnelOptions{Name: "vnc", Description: "noVNC websocket"})
 	if err != nil {
 		return novncWebsocketInfo, errors.Wrap(err, "Failed To Create Tunnel To NoVNC Websocket")
 	}
 	novncWebsocketInfo.PortTunnelOnHost = portTunnelInfo
 
-	client.Info.NovncWebsocketInfo = &novncWebsocketInfo
+	client.updateInfo(func(info *models.ClientInfo) { info.NovncWebsocketInfo = &novncWebsocketInfo })
 	return novncWebsocketInfo, nil
 }
 
-func (client *Client) CreateTunnel(clientPort int) (models.PortTunnelInfo, error) {
-	// Avoid overloading the gost tunnel service by ensure there is at most one client to create tunnel
-	gostTunnelService := client.server.GetTunnelService()
-	gostTunnelService.Lock()
-	defer gostTunnelService.UnLock()
+// TunnelOptions are set by the creator of a tunnel
+type TunnelOptions struct {
+	// Either tcp or udp, tcp if empty
+	Protocol    string
+	Name        string
+	Description string
+	// Zero means the tunnel does not expire, or is never closed for being idle
+	TTL         time.Duration
+	IdleTimeout time.Duration
+
+	// Set for the target end of a tunnel between two clients, which is only bound on the loopback interface
+	peerClientID string
+	peerPort     int
+}
+
+// isUpdate tells if the options change an existing tunnel
+func (options TunnelOptions) isUpdate() bool {
+	return options.Name != "" || options.Description != "" || options.TTL > 0 || options.IdleTimeout > 0
+}
+
+func (options TunnelOptions) apply(tunnel *models.PortTunnelInfo) {
+	tunnel.Name = options.Name
+	tunnel.Description = options.Description
+	tunnel.ExpiresAt = nil
+	if options.TTL > 0 {
+		expiresAt := time.Now().Add(options.TTL)
+		tunnel.ExpiresAt = &expiresAt
+	}
+	tunnel.IdleTimeout = int(options.IdleTimeout.Seconds())
+}
+
+// CreateTunnel tunnels a client port, the options of an existing tunnel are updated if any is given
+func (client *Client) CreateTunnel(clientPort int, options TunnelOptions) (models.PortTunnelInfo, error) {
+	preferredServerPort := 0
+	if client.previousInfo != nil {
+		for _, t := range client.previousInfo.PortTunnels {
+			if t.ClientPort == clientPort && tunnelProtocol(t.Protocol) == tunnelProtocol(options.Protocol) && t.PeerClientID == "" {
+				preferredServerPort = t.ServerPort
+				if options.Name == "" && options.Description == "" {
+					options.Name, options.Description = t.Name, t.Description
+				}
+			}
+		}
+	}
+	return client.createTunnel(clientPort, preferredServerPort, options)
+}
 
+// createTunnel tunnels the client port to the preferred server port if it is available, or to a random one otherwise
+func (client *Client) createTunnel(clientPort int, preferredServerPort int, options TunnelOptions) (models.PortTunnelInfo, error) {
 	var err error
 	var tunnel models.PortTunnelInfo
 
-	client.logger.WithField("Client ID", client.ID).Infof("Creating Tunnel To Client Port %d", clientPort)
+	// The existing tunnel check below and the insert of the new tunnel are done under the same lock
+	client.tunnelLock.Lock()
+	defer client.tunnelLock.Unlock()
+
+	tunnel.DataPlane = client.server.dataPlane()
+	if tunnel.DataPlane == models.DataPlaneGost {
+		// Avoid overloading the gost tunnel service by ensure there is at most one client to create tunnel
+		gostTunnelService := client.server.GetTunnelService()
+		gostTunnelService.Lock()
+		defer gostTunnelService.UnLock()
+		tunnel.GostServerPort = gostTunnelService.Port
+	}
+
+	tunnel.ClientPort = clientPort
+	tunnel.Protocol = tunnelProtocol(options.Protocol)
+	if tunnel.Protocol != models.ProtocolTCP && tunnel.Protocol != models.ProtocolUDP {
+		return tunnel, errors.New("Unsupported tunnel protocol: " + tunnel.Protocol)
+	}
+
+	client.logger.WithField("Client ID", client.ID).Infof("Creating %s Tunnel To Client Port %d", tunnel.Protocol, clientPort)
 	//Check if the client port is already tunnelled
-	for _, t := range client.Info.PortTunnels {
-		if t.ClientPort == clientPort {
-			return t, nil
+	existing, found, err := client.updateExistingTunnel(clientPort, tunnel.Protocol, options)
+	if found {
+		return existing, err
+	}
+
+	portRange := client.server.portRangeOf(client.tags())
+	if preferredServerPort > 0 {
+		if err = client.server.reserveSpecificPort(portRange, preferredServerPort, client.ID); err == nil {
+			tunnel.ServerPort = preferredServerPort
+		} else {
+			client.logger.WithField("Client ID", client.ID).Info(errors.Wrapf(err, "Unable To Reuse Server Port %d", preferredServerPort))
+		}
+	}
+	if tunnel.ServerPort == 0 {
+		tunnel.ServerPort, err = client.server.portsManager.ReservePortInRange(portRange, client.ID)
+		if err != nil {
+			return tunnel, err
 		}
 	}
+	options.apply(&tunnel)
+	tunnel.BindAddress = client.server.TunnelBindAddress
+	if options.peerClientID != "" {
+		tunnel.BindAddress = "127.0.0.1"
+		tunnel.PeerClientID, tunnel.PeerPort = options.peerClientID, options.peerPort
+	}
 
-	tunnel.GostServerPort = gostTunnelService.Port
-	tunnel.ServerPort, err = client.server.portsManager.ReservePort()
-	if err != nil {
-		return tunnel, err
+	// The server port of UDP tunnels is served by a relay, which streams the datagrams through a TCP tunnel on the relay port
+	bindAddr := net.JoinHostPort(tunnel.BindAddress, strconv.Itoa(tunnel.ServerPort))
+	gostBindHost, gostBindPort := tunnel.BindAddress, tunnel.ServerPort
+	if tunnel.Protocol == models.ProtocolUDP {
+		if tunnel.RelayPort, err = client.server.portsManager.ReservePort(client.ID); err != nil {
+			client.server.portsManager.ReleasePort(tunnel.ServerPort)
+			return tunnel, err
+		}
+		gostBindHost, gostBindPort = "127.0.0.1", tunnel.RelayPort
+		bindAddr = "127.0.0.1:" + strconv.Itoa(tunnel.RelayPort)
+
+		serverPort := tunnel.ServerPort
+		relay, err := NewUDPRelay(
+			net.JoinHostPort(tunnel.BindAddress, strconv.Itoa(tunnel.ServerPort)),
+			"127.0.0.1:"+strconv.Itoa(tunnel.RelayPort),
+			func() { client.server.tunnelActivity.Touch(serverPort) },
+			client.logger,
+		)
+		if err != nil {
+			client.releaseTunnel(tunnel)
+			return tunnel, err
+		}
+		client.udpRelays.Store(tunnel.ServerPort, relay)
 	}
-	tunnel.ClientPort = clientPort
+
+	if tunnel.DataPlane == models.DataPlaneYamux {
+		st, err := NewStreamTunnel(bindAddr, client.session, tunnel, client.server.tunnelActivity, client.logger)
+		if err != nil {
+			client.releaseTunnel(tunnel)
+			return tunnel, err
+		}
+		client.streamTunnels.Store(tunnel.ServerPort, st)
+	} else {
+		tunnel.GostUser, tunnel.GostPassword, err = client.server.tunnelCredentials.Issue(gostBindHost, gostBindPort)
+		if err != nil {
+			client.releaseTunnel(tunnel)
+			return tunnel, errors.Wrap(err, "Failed To Issue Tunnel Credential")
+		}
+	}
+
+	tunnel.RateLimit = client.previousTunnelRateLimit(tunnel)
+	client.applyTunnelRateLimit(tunnel)
 
 	stream, err := task.NewTask(client.ctx, task.PortTunnelRequest, client.logger).Request(client.session, utils.StructToBytes(tunnel))
 	if err != nil {
-		client.server.portsManager.ReleasePort(tunnel.ServerPort)
+		client.releaseTunnel(tunnel)
 		return tunnel, errors.Wrap(err, "Failed To Instruct Client To Do Port Forwarding")
 	}
 
 	err = task.WaitTaskCompleteSignal(60*time.Second, stream)
 	if err != nil {
-		client.server.portsManager.ReleasePort(tunnel.ServerPort)
+		client.releaseTunnel(tunnel)
 		return tunnel, errors.New("Client Failed To Create Tunnel | Client ID: " + client.ID)
 	}
-	client.logger.WithField("Client ID", client.ID).Infof("Created Tunnel | Host Port: %d | Client Port: %d", tunnel.ServerPort, tunnel.ClientPort)
-	client.Info.PortTunnels = append(client.Info.PortTunnels, tunnel)
+	client.logger.WithField("Client ID", client.ID).Infof("Created %s Tunnel | Host Port: %d | Client Port: %d", tunnel.Protocol, tunnel.ServerPort, tunnel.ClientPort)
+	tunnel.CreatedAt = time.Now()
+	if options.TTL > 0 {
+		// The TTL starts once the tunnel is up
+		expiresAt := tunnel.CreatedAt.Add(options.TTL)
+		tunnel.ExpiresAt = &expiresAt
+	}
+	client.updateInfo(func(info *models.ClientInfo) { info.PortTunnels = append(info.PortTunnels, tunnel) })
+	return tunnel, nil
+}
+
+// updateExistingTunnel applies the options to the tunnel of the client port if there is one, found tells whether there is
+func (client *Client) updateExistingTunnel(clientPort int, protocol string, options TunnelOptions) (tunnel models.PortTunnelInfo, found bool, err error) {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+
+	for i, t := range client.Info.PortTunnels {
+		if t.ClientPort == clientPort && tunnelProtocol(t.Protocol) == protocol && !t.Listen && t.PeerClientID == options.peerClientID {
+			if options.peerClientID != "" {
+				return t, true, errors.Errorf("Client port %d is already tunnelled to client %s", clientPort, options.peerClientID)
+			}
+			if options.isUpdate() {
+				options.apply(&client.Info.PortTunnels[i])
+				client.saveInfoLocked()
+			}
+			return client.Info.PortTunnels[i], true, nil
+		}
+	}
+	return tunnel, false, nil
+}
+
+// copyInfo returns a copy of the client info, which does not change along with the client
+func (client *Client) copyInfo() models.ClientInfo {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+
+	info := client.Info
+	info.Tags = append([]string{}, client.Info.Tags...)
+	info.PortTunnels = append([]models.PortTunnelInfo{}, client.Info.PortTunnels...)
+	return info
+}
+
+// portTunnels returns a copy of the tunnels of the client
+func (client *Client) portTunnels() []models.PortTunnelInfo {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+
+	return append([]models.PortTunnelInfo{}, client.Info.PortTunnels...)
+}
+
+func (client *Client) tags() []string {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+
+	return append([]string{}, client.Info.Tags...)
+}
+
+// Tunnels returns the tunnels of the client along with the time of their last traffic and their byte counts
+func (client *Client) Tunnels() []models.PortTunnelInfo {
+	tunnels := []models.PortTunnelInfo{}
+	for _, t := range client.portTunnels() {
+		if lastActivity := client.server.tunnelActivity.LastActivity(t.ServerPort); !lastActivity.IsZero() {
+			t.LastActivity = &lastActivity
+		}
+		traffic := client.tunnelTraffic(t)
+		t.BytesReceived, t.BytesSent = traffic.Received, traffic.Sent
+		tunnels = append(tunnels, t)
+	}
+	return tunnels
+}
+
+// GetInfo returns the info of the client along with the traffic of its tunnels
+func (client *Client) GetInfo() models.ClientInfo {
+	info := client.copyInfo()
+	info.PortTunnels = client.Tunnels()
+	traffic := client.bandwidth.Traffic()
+	info.BytesReceived, info.BytesSent = traffic.Received, traffic.Sent
+	info.RateLimit = client.bandwidth.Limit()
+	return info
+}
+
+// tunnelProtocol defaults the protocol to tcp, as for tunnels created before UDP support
+func tunnelProtocol(protocol string) string {
+	if protocol == "" {
+		return models.ProtocolTCP
+	}
+	return protocol
+}
+
+// GetTunnel looks up the tunnel of a client port, protocol is tcp if empty
+func (client *Client) GetTunnel(clientPort int, protocol string) (models.PortTunnelInfo, error) {
+	protocol = tunnelProtocol(protocol)
+	for _, t := range client.Tunnels() {
+		if t.ClientPort == clientPort && tunnelProtocol(t.Protocol) == protocol && t.PeerClientID == "" {
+			return t, nil
+		}
+	}
+	return models.PortTunnelInfo{}, errors.Errorf("No %s tunnel to client port %d", protocol, clientPort)
+}
+
+// GetPeerTunnel looks up the tunnel listening on the client port and leading to another client
+func (client *Client) GetPeerTunnel(listenPort int) (models.PortTunnelInfo, error) {
+	for _, t := range client.Tunnels() {
+		if t.ClientPort == listenPort && t.Listen && t.PeerClientID != "" {
+			return t, nil
+		}
+	}
+	return models.PortTunnelInfo{}, errors.Errorf("No tunnel listening on client port %d", listenPort)
+}
+
+// CreatePeerTunnel listens on localhost:listenPort of the client, and forwards the connections to the port of the peer client through the server
+func (client *Client) CreatePeerTunnel(listenPort int, peer *Client, peerPort int, options TunnelOptions) (models.PortTunnelInfo, error) {
+	var tunnel models.PortTunnelInfo
+	if peer.ID == client.ID {
+		return tunnel, errors.New("Peer must be another client")
+	}
+	if tunnelProtocol(options.Protocol) != models.ProtocolTCP {
+		return tunnel, errors.New("Only TCP tunnels are supported between clients")
+	}
+	if client.isListening(listenPort) {
+		return tunnel, errors.Errorf("Client port %d is already listened on", listenPort)
+	}
+
+	options.peerClientID, options.peerPort = client.ID, listenPort
+	target, err := peer.createTunnel(peerPort, 0, options)
+	if err != nil {
+		return tunnel, errors.Wrap(err, "Failed To Create Tunnel To Peer Client")
+	}
+
+	client.logger.WithField("Client ID", client.ID).Infof("Creating Tunnel From Client Port %d To Port %d Of Client %s", listenPort, peerPort, peer.ID)
+	tunnel.ServerPort = target.ServerPort
+	tunnel.ClientPort = listenPort
+	tunnel.PeerClientID, tunnel.PeerPort = peer.ID, peerPort
+	tunnel.TargetAddress = "127.0.0.1:" + strconv.Itoa(target.ServerPort)
+	options.apply(&tunnel)
+	tunnel.ExpiresAt = target.ExpiresAt
+	tunnel, err = client.createListeningTunnel(tunnel)
+	if err != nil {
+		peer.closeTunnel(target)
+	}
+	return tunnel, err
+}
+
+// CreateReverseTunnel listens on localhost:listenPort of the client, and forwards the connections to the target address from the server
+func (client *Client) CreateReverseTunnel(listenPort int, targetAddress string, options TunnelOptions) (models.PortTunnelInfo, error) {
+	var tunnel models.PortTunnelInfo
+	if tunnelProtocol(options.Protocol) != models.ProtocolTCP {
+		return tunnel, errors.New("Only TCP reverse tunnels are supported")
+	}
+	// The traffic of reverse tunnels is not tracked
+	if options.IdleTimeout > 0 {
+		return tunnel, errors.New("Idle timeout is not supported for reverse tunnels")
+	}
+	if !client.server.ReverseTunnelAllowlist.Allows(targetAddress) {
+		return tunnel, errors.New("Target address is not in the reverse tunnel allowlist: " + targetAddress)
+	}
+
+	client.logger.WithField("Client ID", client.ID).Infof("Creating Reverse Tunnel From Client Port %d To %s", listenPort, targetAddress)
+	tunnel.ClientPort = listenPort
+	tunnel.TargetAddress = targetAddress
+	options.apply(&tunnel)
+	return client.createListeningTunnel(tunnel)
+}
+
+// isListening tells if a tunnel already listens on the client port
+func (client *Client) isListening(listenPort int) bool {
+	for _, t := range client.portTunnels() {
+		if t.ClientPort == listenPort && t.Listen {
+			return true
+		}
+	}
+	return false
+}
+
+// createListeningTunnel instructs the client to listen on the client port of the tunnel, and to forward the connections to its target address
+// through the gost tunnel service, or through the control session on the yamux data plane
+func (client *Client) createListeningTunnel(tunnel models.PortTunnelInfo) (models.PortTunnelInfo, error) {
+	var err error
+
+	client.tunnelLock.Lock()
+	defer client.tunnelLock.Unlock()
+	if client.isListening(tunnel.ClientPort) {
+		return tunnel, errors.Errorf("Client port %d is already listened on", tunnel.ClientPort)
+	}
+	tunnel.Protocol = models.ProtocolTCP
+	tunnel.Listen = true
+	tunnel.DataPlane = client.server.dataPlane()
+	if tunnel.DataPlane == models.DataPlaneGost {
+		gostTunnelService := client.server.GetTunnelService()
+		gostTunnelService.Lock()
+		defer gostTunnelService.UnLock()
+
+		tunnel.GostServerPort = gostTunnelService.Port
+		tunnel.GostUser, tunnel.GostPassword, err = client.server.tunnelCredentials.IssueForward(tunnel.TargetAddress)
+		if err != nil {
+			return tunnel, errors.Wrap(err, "Failed To Issue Tunnel Credential")
+		}
+	}
+
+	stream, err := task.NewTask(client.ctx, task.PortTunnelRequest, client.logger).Request(client.session, utils.StructToBytes(tunnel))
+	if err == nil {
+		err = task.WaitTaskCompleteSignal(60*time.Second, stream)
+	}
+	if err != nil {
+		client.server.tunnelCredentials.Revoke(tunnel.GostUser)
+		return tunnel, errors.New("Client Failed To Listen On Port " + strconv.Itoa(tunnel.ClientPort) + " | Client ID: " + client.ID)
+	}
+	tunnel.CreatedAt = time.Now()
+	client.updateInfo(func(info *models.ClientInfo) { info.PortTunnels = append(info.PortTunnels, tunnel) })
 	return tunnel, nil
 }
 
+// ClosePeerTunnel tears down both ends of the tunnel listening on the client port
+func (client *Client) ClosePeerTunnel(listenPort int) (models.PortTunnelInfo, error) {
+	tunnel, err := client.GetPeerTunnel(listenPort)
+	if err != nil {
+		return tunnel, err
+	}
+	return tunnel, client.closeTunnel(tunnel)
+}
+
+// peerEndExists tells if the other end of a tunnel between two clients is still up
+func (client *Client) peerEndExists(tunnel models.PortTunnelInfo) bool {
+	peer, err := client.server.GetClientById(tunnel.PeerClientID)
+	if err != nil {
+		return false
+	}
+	for _, t := range peer.portTunnels() {
+		if t.ServerPort == tunnel.ServerPort && t.Listen != tunnel.Listen {
+			return true
+		}
+	}
+	return false
+}
+
+// The target end of a tunnel between two clients is up before the listening end, which takes up to a minute
+const peerTunnelGracePeriod = 2 * time.Minute
+
+type tunnelExpiry struct {
+	Tunnel models.PortTunnelInfo
+	Reason string
+}
+
+// expiredTunnels returns the tunnels past their TTL or idle timeout, along with the reason
+func (client *Client) expiredTunnels(now time.Time) []tunnelExpiry {
+	expired := []tunnelExpiry{}
+	for _, t := range client.Tunnels() {
+		lastActivity := t.CreatedAt
+		if t.LastActivity != nil && t.LastActivity.After(lastActivity) {
+			lastActivity = *t.LastActivity
+		}
+		if t.ExpiresAt != nil && now.After(*t.ExpiresAt) {
+			expired = append(expired, tunnelExpiry{t, "ttl"})
+		} else if t.IdleTimeout > 0 && now.Sub(lastActivity) > time.Duration(t.IdleTimeout)*time.Second {
+			expired = append(expired, tunnelExpiry{t, "idle"})
+		} else if t.PeerClientID != "" && now.Sub(t.CreatedAt) > peerTunnelGracePeriod && !client.peerEndExists(t) {
+			expired = append(expired, tunnelExpiry{t, "peer"})
+		}
+	}
+	return expired
+}
+
+// isServiceTunnel tells if the server port belongs to one of the services set up on connect, which cannot be closed on their own
+func (client *Client) isServiceTunnel(serverPort int) bool {
+	info := client.copyInfo()
+	return (info.SSHTunnel != nil && info.SSHTunnel.ServerPort == serverPort) ||
+		(info.GottyWebTerminalInfo != nil && info.GottyWebTerminalInfo.PortTunnelOnHost.ServerPort == serverPort) ||
+		(info.NovncWebsocketInfo != nil && info.NovncWebsocketInfo.PortTunnelOnHost.ServerPort == serverPort) ||
+		(info.FilebrowserInfo != nil && info.FilebrowserInfo.PortTunnelOnHost.ServerPort == serverPort) ||
+		(info.GatewayInfo != nil && info.GatewayInfo.PortTunnelOnHost.ServerPort == serverPort)
+}
+
+// CloseTunnel tears down the tunnel of a client port, its server port is released once the client has closed it
+func (client *Client) CloseTunnel(clientPort int, protocol string) (models.PortTunnelInfo, error) {
+	tunnel, err := client.GetTunnel(clientPort, protocol)
+	if err != nil {
+		return tunnel, err
+	}
+	if client.isServiceTunnel(tunnel.ServerPort) {
+		return tunnel, errors.Errorf("Tunnel to client port %d is used by the %s service", clientPort, tunnel.Name)
+	}
+	return tunnel, client.closeTunnel(tunnel)
+}
+
+// closeTunnel tears down a tunnel of the client, along with the other end of a tunnel between two clients
+func (client *Client) closeTunnel(tunnel models.PortTunnelInfo) error {
+	client.logger.WithField("Client ID", client.ID).Infof("Closing Tunnel | Host Port: %d | Client Port: %d", tunnel.ServerPort, tunnel.ClientPort)
+	stream, err := task.NewTask(client.ctx, task.ClosePortTunnelRequest, client.logger).Request(client.session, utils.StructToBytes(tunnel))
+	if err != nil {
+		return errors.Wrap(err, "Failed To Instruct Client To Close Tunnel")
+	}
+	if err = task.WaitTaskCompleteSignal(30*time.Second, stream); err != nil {
+		return errors.New("Client Failed To Close Tunnel | Client ID: " + client.ID)
+	}
+
+	client.updateInfo(func(info *models.ClientInfo) {
+		for i, t := range info.PortTunnels {
+			// Reverse tunnels have no server port
+			if t.ServerPort == tunnel.ServerPort && t.ClientPort == tunnel.ClientPort && t.Listen == tunnel.Listen {
+				info.PortTunnels = append(info.PortTunnels[:i], info.PortTunnels[i+1:]...)
+				break
+			}
+		}
+	})
+	client.releaseTunnel(tunnel)
+
+	if tunnel.PeerClientID == "" {
+		return nil
+	}
+	peer, err := client.server.GetClientById(tunnel.PeerClientID)
+	if err != nil {
+		return nil
+	}
+	for _, t := range peer.portTunnels() {
+		if t.ServerPort == tunnel.ServerPort && t.Listen != tunnel.Listen {
+			return errors.Wrap(peer.closeTunnel(t), "Failed To Close Tunnel On Peer Client")
+		}
+	}
+	return nil
+}
+
+// releaseTunnel frees the server port of a tunnel and revokes its gost credential
+func (client *Client) releaseTunnel(tunnel models.PortTunnelInfo) {
+	// The server port of a listening end belongs to the other end
+	if tunnel.Listen {
+		client.server.tunnelCredentials.Revoke(tunnel.GostUser)
+		return
+	}
+	if st, ok := client.streamTunnels.Load(tunnel.ServerPort); ok {
+		st.(*StreamTunnel).Close()
+		client.streamTunnels.Delete(tunnel.ServerPort)
+	}
+	if relay, ok := client.udpRelays.Load(tunnel.ServerPort); ok {
+		relay.(*UDPRelay).Close()
+		client.udpRelays.Delete(tunnel.ServerPort)
+	}
+	if tunnel.RelayPort > 0 {
+		client.server.tunnelActivity.Forget(tunnel.RelayPort)
+		client.server.portsManager.ReleasePort(tunnel.RelayPort)
+	}
+	client.server.tunnelActivity.Forget(tunnel.ServerPort)
+	client.server.portsManager.ReleasePort(tunnel.ServerPort)
+	client.server.tunnelCredentials.Revoke(tunnel.GostUser)
+}
+
 func (client *Client) Stop() error {
 	defer func() {
-		for _, t := range client.Info.PortTunnels {
-			client.server.portsManager.ReleasePort(t.ServerPort)
-		}
+		client.infoLock.Lock()
+		tunnels := client.Info.PortTunnels
 		client.Info.PortTunnels = []models.PortTunnelInfo{}
+		client.infoLock.Unlock()
+
+		for _, t := range tunnels {
+			client.releaseTunnel(t)
+		}
 	}()
 
 	client.stop()
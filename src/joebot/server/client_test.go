@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/hashicorp/yamux"
+	"github.com/twinj/uuid"
+)
+
+// newFakeClient returns a client on the yamux data plane, whose other end confirms every task after the delay.
+// The number of tasks requested from the client is returned along with it
+func newFakeClient(t *testing.T, server *Server, delay time.Duration) (*Client, *int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(uuid.NewV4().String(), server, &serverConn, server.logger)
+	if client.session, err = yamux.Server(serverConn, nil); err != nil {
+		t.Fatal(err)
+	}
+	clientSession, err := yamux.Client(clientConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		clientSession.Close()
+		client.session.Close()
+	})
+
+	requests := new(int32)
+	go func() {
+		for {
+			stream, err := clientSession.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer stream.Close()
+				header := make([]byte, 12)
+				if _, err := io.ReadFull(stream, header); err != nil {
+					return
+				}
+				if _, err := io.CopyN(ioutil.Discard, stream, int64(binary.LittleEndian.Uint64(header[4:]))); err != nil {
+					return
+				}
+				atomic.AddInt32(requests, 1)
+				time.Sleep(delay)
+				task.ConfirmTaskComplete(stream)
+			}()
+		}
+	}()
+	return client, requests
+}
+
+func TestCreateTunnelConcurrently(t *testing.T) {
+	server := NewServer(benchLogger())
+	server.DataPlane = models.DataPlaneYamux
+	server.TunnelBindAddress = "127.0.0.1"
+	client, requests := newFakeClient(t, server, 50*time.Millisecond)
+
+	tunnels := make([]models.PortTunnelInfo, 5)
+	errs := make([]error, len(tunnels))
+	var wg sync.WaitGroup
+	for i := range tunnels {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tunnels[i], errs[i] = client.CreateTunnel(8080, TunnelOptions{})
+		}(i)
+	}
+	wg.Wait()
+	defer client.Stop()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Error(err)
+		} else if tunnels[i].ServerPort != tunnels[0].ServerPort {
+			t.Errorf("client port tunnelled to server ports %d and %d", tunnels[0].ServerPort, tunnels[i].ServerPort)
+		}
+	}
+	if n := len(client.portTunnels()); n != 1 {
+		t.Errorf("%d tunnels created", n)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("%d tunnel requests sent to the client", n)
+	}
+}
+
+func TestCreateReverseTunnelConcurrently(t *testing.T) {
+	server := NewServer(benchLogger())
+	server.DataPlane = models.DataPlaneYamux
+	allowlist, err := ParseTargetAllowlist([]string{"127.0.0.1:22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.ReverseTunnelAllowlist = allowlist
+	client, requests := newFakeClient(t, server, 50*time.Millisecond)
+
+	var created int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.CreateReverseTunnel(2222, "127.0.0.1:22", TunnelOptions{}); err == nil {
+				atomic.AddInt32(&created, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Errorf("%d reverse tunnels created on the same client port", created)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("%d tunnel requests sent to the client", n)
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ginuerzh/gost"
+	"github.com/harmonicinc-com/joebot/client"
+	"github.com/harmonicinc-com/joebot/handler"
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/hashicorp/yamux"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// The benchmarks compare the data planes on tunnels to an echo server, the gost tunnel service is used without the throttling of GostTunnel.Lock
+
+func benchLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func freePort(b *testing.B) int {
+	port, err := utils.GetFreePort(0, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return port
+}
+
+func startEchoServer(b *testing.B) (int, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, func() { ln.Close() }
+}
+
+// roundTrip sends the payload through the tunnel and reads it back
+func roundTrip(conn net.Conn, payload []byte, buf []byte) error {
+	if _, err := conn.Write(payload); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(conn, buf[:len(payload)])
+	return err
+}
+
+// waitTunnel dials the server port until the tunnel is up
+func waitTunnel(b *testing.B, addr string) {
+	payload, buf := []byte("ping"), make([]byte, 4)
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(time.Second))
+			err = roundTrip(conn, payload, buf)
+			conn.Close()
+			if err == nil {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	b.Fatal("Tunnel is not up: " + addr)
+}
+
+// benchDataPlane sets up both ends of tunnels on one data plane
+type benchDataPlane interface {
+	// open tunnels the server port to the client port, and returns a function closing the tunnel
+	open(b *testing.B, serverPort int, clientPort int) func()
+	close()
+}
+
+type gostDataPlane struct {
+	tunnel      *GostTunnel
+	credentials *TunnelCredentials
+	fingerprint string
+}
+
+func newGostDataPlane(b *testing.B) benchDataPlane {
+	cert, err := LoadOrCreateGostCertificate("", "", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	fingerprint, err := GostHostKeyFingerprint(cert)
+	if err != nil {
+		b.Fatal(err)
+	}
+	dp := &gostDataPlane{credentials: NewTunnelCredentials(), fingerprint: fingerprint}
+	dp.tunnel = NewGostTunnel(freePort(b), cert, dp.credentials)
+	go dp.tunnel.Serve()
+	return dp
+}
+
+// benchTransporter keeps the SSH connections of a tunnel, as the client does
+type benchTransporter struct {
+	gost.Transporter
+	conns []net.Conn
+	lock  sync.Mutex
+}
+
+func (tr *benchTransporter) Dial(addr string, options ...gost.DialOption) (net.Conn, error) {
+	conn, err := tr.Transporter.Dial(addr, options...)
+	if err == nil {
+		tr.lock.Lock()
+		tr.conns = append(tr.conns, conn)
+		tr.lock.Unlock()
+	}
+	return conn, err
+}
+
+func (dp *gostDataPlane) open(b *testing.B, serverPort int, clientPort int) func() {
+	user, password, err := dp.credentials.Issue("127.0.0.1", serverPort)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	gostServerAddr := "127.0.0.1:" + strconv.Itoa(dp.tunnel.Port)
+	transporter := &benchTransporter{Transporter: gost.SSHForwardTransporter()}
+	chain := gost.NewChain(gost.Node{
+		Protocol:  "forward",
+		Transport: "ssh",
+		Addr:      gostServerAddr,
+		HandshakeOptions: []gost.HandshakeOption{
+			gost.AddrHandshakeOption(gostServerAddr),
+			gost.UserHandshakeOption(url.UserPassword(user, password)),
+			gost.SSHConfigHandshakeOption(&gost.SSHConfig{
+				HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+					if ssh.FingerprintSHA256(key) != dp.fingerprint {
+						return ssh.ErrNoAuth
+					}
+					return nil
+				},
+			}),
+		},
+		Client: &gost.Client{
+			Connector:   gost.SSHRemoteForwardConnector(),
+			Transporter: transporter,
+		},
+	})
+
+	ln, err := gost.TCPRemoteForwardListener("127.0.0.1:"+strconv.Itoa(serverPort), chain)
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := &gost.Server{Listener: ln}
+	h := gost.TCPRemoteForwardHandler("127.0.0.1:" + strconv.Itoa(clientPort))
+	h.Init(gost.AddrHandlerOption(ln.Addr().String()), gost.ChainHandlerOption(chain))
+	go s.Serve(h)
+
+	return func() {
+		s.Close()
+		transporter.lock.Lock()
+		for _, conn := range transporter.conns {
+			conn.Close()
+		}
+		transporter.lock.Unlock()
+		dp.credentials.Revoke(user)
+	}
+}
+
+func (dp *gostDataPlane) close() {
+	dp.tunnel.Stop()
+}
+
+type yamuxDataPlane struct {
+	session *yamux.Session
+	client  *client.Client
+	stop    context.CancelFunc
+}
+
+func newYamuxDataPlane(b *testing.B) benchDataPlane {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	serverConn, err := ln.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	dp := &yamuxDataPlane{}
+	if dp.session, err = yamux.Server(serverConn, nil); err != nil {
+		b.Fatal(err)
+	}
+	clientSession, err := yamux.Client(clientConn, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var ctx context.Context
+	ctx, dp.stop = context.WithCancel(context.Background())
+	dp.client = client.NewClient("127.0.0.1", 0, 0, 65535, nil, benchLogger())
+	inHandler := handler.NewIncomingRequestHandler(ctx, clientSession, benchLogger())
+	inHandler.RegisterTask(client.NewTunnelStreamTask(dp.client))
+	inHandler.Start()
+	return dp
+}
+
+func (dp *yamuxDataPlane) open(b *testing.B, serverPort int, clientPort int) func() {
+	tunnel := models.PortTunnelInfo{ServerPort: serverPort, ClientPort: clientPort, DataPlane: models.DataPlaneYamux}
+	st, err := NewStreamTunnel("127.0.0.1:"+strconv.Itoa(serverPort), dp.session, tunnel, nil, benchLogger())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// The client confirms on the stream of the port tunnel request
+	stream, peer := net.Pipe()
+	go io.Copy(ioutil.Discard, peer)
+	if err = client.NewPortTunnelTask(dp.client).Handle(utils.StructToBytes(tunnel), stream); err != nil {
+		b.Fatal(err)
+	}
+	stream.Close()
+
+	return func() {
+		st.Close()
+		dp.client.CloseTunnel(tunnel)
+	}
+}
+
+func (dp *yamuxDataPlane) close() {
+	dp.stop()
+	dp.session.Close()
+}
+
+var dataPlanes = []struct {
+	name string
+	new  func(b *testing.B) benchDataPlane
+}{
+	{models.DataPlaneGost, newGostDataPlane},
+	{models.DataPlaneYamux, newYamuxDataPlane},
+}
+
+// BenchmarkDataPlaneSetup measures the creation of a tunnel until its first connection goes through
+func BenchmarkDataPlaneSetup(b *testing.B) {
+	for _, dataPlane := range dataPlanes {
+		b.Run(dataPlane.name, func(b *testing.B) {
+			echoPort, stopEcho := startEchoServer(b)
+			defer stopEcho()
+			dp := dataPlane.new(b)
+			defer dp.close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				serverPort := freePort(b)
+				closeTunnel := dp.open(b, serverPort, echoPort)
+				waitTunnel(b, "127.0.0.1:"+strconv.Itoa(serverPort))
+				b.StopTimer()
+				closeTunnel()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// BenchmarkDataPlaneConnection measures a new connection through an existing tunnel, with a small round trip
+func BenchmarkDataPlaneConnection(b *testing.B) {
+	for _, dataPlane := range dataPlanes {
+		b.Run(dataPlane.name, func(b *testing.B) {
+			echoPort, stopEcho := startEchoServer(b)
+			defer stopEcho()
+			dp := dataPlane.new(b)
+			defer dp.close()
+			serverPort := freePort(b)
+			addr := "127.0.0.1:" + strconv.Itoa(serverPort)
+			defer dp.open(b, serverPort, echoPort)()
+			waitTunnel(b, addr)
+
+			payload, buf := []byte("ping"), make([]byte, 4)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err = roundTrip(conn, payload, buf); err != nil {
+					b.Fatal(err)
+				}
+				conn.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkDataPlaneThroughput measures the round trip of 64KB chunks on a single connection
+func BenchmarkDataPlaneThroughput(b *testing.B) {
+	for _, dataPlane := range dataPlanes {
+		b.Run(dataPlane.name, func(b *testing.B) {
+			echoPort, stopEcho := startEchoServer(b)
+			defer stopEcho()
+			dp := dataPlane.new(b)
+			defer dp.close()
+			serverPort := freePort(b)
+			addr := "127.0.0.1:" + strconv.Itoa(serverPort)
+			defer dp.open(b, serverPort, echoPort)()
+			waitTunnel(b, addr)
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			payload, buf := make([]byte, 64*1024), make([]byte, 64*1024)
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err = roundTrip(conn, payload, buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultExecTimeout is the timeout of the commands run without one
+	DefaultExecTimeout = 60
+	// execOutputLimit is how much of each stream of the output of a command is kept for the result
+	execOutputLimit = 1 << 20
+)
+
+// Exec runs the command on the client and calls output with each chunk of its output, until the command exits.
+// The command is killed on the client once ctx is done
+func (client *Client) Exec(ctx context.Context, request models.ExecRequest, output func(models.ExecOutput) error) (models.ExecExit, error) {
+	if request.Command == "" {
+		return models.ExecExit{}, errors.New("Command Is Empty")
+	}
+	if request.Timeout <= 0 {
+		request.Timeout = DefaultExecTimeout
+	}
+
+	client.logger.WithField("Client ID", client.ID).Infof("Running Command On Client | Command: %s %v", request.Command, request.Args)
+	stream, err := task.NewTask(client.ctx, task.ExecRequest, client.logger).Request(client.session, utils.StructToBytes(request))
+	if err != nil {
+		return models.ExecExit{}, errors.Wrap(err, "Failed To Instruct Client To Run Command")
+	}
+	defer stream.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-done:
+		}
+	}()
+
+	// The client kills the command on timeout, then sends its exit code
+	deadline := time.Now().Add(time.Duration(request.Timeout)*time.Second + 30*time.Second)
+	for {
+		body, err := task.ReceiveStreamedObject(stream, deadline)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return models.ExecExit{}, errors.Wrap(err, "Failed To Receive Command Output From Client")
+		}
+		var chunk models.ExecOutput
+		if err = utils.BytesToStruct(body, &chunk); err != nil {
+			return models.ExecExit{}, errors.Wrap(err, "Unable to decode command output into ExecOutput object")
+		}
+		if chunk.Exit != nil {
+			return *chunk.Exit, nil
+		}
+		if err = output(chunk); err != nil {
+			return models.ExecExit{}, err
+		}
+	}
+}
+
+// ExecResult runs the command on the client and returns its whole output, of which the first megabyte of each stream is kept
+func (client *Client) ExecResult(ctx context.Context, request models.ExecRequest) (models.ExecResult, error) {
+	var result models.ExecResult
+	exit, err := client.Exec(ctx, request, func(chunk models.ExecOutput) error {
+		out := &result.Stdout
+		if chunk.Stream == models.ExecStderr {
+			out = &result.Stderr
+		}
+		data := chunk.Data
+		if len(*out)+len(data) > execOutputLimit {
+			data = data[:execOutputLimit-len(*out)]
+			result.Truncated = true
+		}
+		*out += data
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	result.ExecExit = exit
+	return result, nil
+}
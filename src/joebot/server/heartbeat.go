@@ -0,0 +1,76 @@
+package server
+
+import (
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/pkg/errors"
+)
+
+// Defaults of the heartbeat settings of the server
+const (
+	DefaultHeartbeatInterval  = 10 * time.Second
+	DefaultHeartbeatMaxMissed = 3
+	DefaultDegradedRTT        = 500 * time.Millisecond
+)
+
+// heartbeat pings the client through its yamux session until the client is stopped,
+// and evicts it once too many heartbeats in a row are missed
+func (client *Client) heartbeat() {
+	interval := client.server.HeartbeatInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Ping times out after the connection write timeout of the session
+		rtt, err := client.session.Ping()
+		if client.ctx.Err() != nil {
+			return
+		}
+		maxMissed := client.server.HeartbeatMaxMissed
+		if missed := client.recordHeartbeat(rtt, err); maxMissed > 0 && missed >= maxMissed {
+			client.infoLock.Lock()
+			client.Info.Status = models.ClientOffline
+			client.infoLock.Unlock()
+			client.ExitIfError(errors.Errorf("%d Heartbeats Missed In A Row", missed), "Evicting Stalled Client")
+			return
+		}
+	}
+}
+
+// recordHeartbeat updates the RTT, missed beats and status of the client with the result of a ping, and returns the missed beats
+func (client *Client) recordHeartbeat(rtt time.Duration, err error) int {
+	client.infoLock.Lock()
+	defer client.infoLock.Unlock()
+
+	logger := client.logger.WithField("Client ID", client.ID)
+	if err != nil {
+		client.Info.MissedBeats++
+		logger.Warn(errors.Wrapf(err, "Missed Heartbeat %d", client.Info.MissedBeats))
+	} else {
+		now := time.Now()
+		client.Info.MissedBeats = 0
+		client.Info.RTT = float64(rtt) / float64(time.Millisecond)
+		client.Info.LastHeartbeat = &now
+	}
+
+	status := models.ClientOnline
+	degradedRTT := client.server.DegradedRTT
+	if client.Info.MissedBeats > 0 || (degradedRTT > 0 && rtt > degradedRTT) {
+		status = models.ClientDegraded
+	}
+	if status != client.Info.Status {
+		logger.WithField("RTT", rtt).Info("Client Status Changed To " + status)
+		client.Info.Status = status
+	}
+	return client.Info.MissedBeats
+}
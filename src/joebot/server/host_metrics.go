@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+// Samples of host metrics kept per client, an hour at the default interval of the clients
+const hostMetricsHistorySize = 120
+
+type HostMetricsReportTask struct {
+	handleClient *Client
+	*task.Task
+}
+
+func NewHostMetricsReportTask(client *Client) *HostMetricsReportTask {
+	return &HostMetricsReportTask{
+		client,
+		task.NewTask(client.ctx, task.HostMetricsReport, client.logger),
+	}
+}
+
+func (t *HostMetricsReportTask) Handle(body []byte, stream net.Conn) error {
+	var metrics models.HostMetrics
+	if err := utils.BytesToStruct(body, &metrics); err != nil {
+		return errors.Wrap(err, "Unable to decode request body into HostMetrics object")
+	}
+	t.handleClient.addHostMetrics(metrics)
+	return nil
+}
+
+// addHostMetrics appends a sample to the rolling history of the client, and makes it the latest metrics of the client info
+func (client *Client) addHostMetrics(metrics models.HostMetrics) {
+	client.hostMetricsLock.Lock()
+	client.hostMetrics = append(client.hostMetrics, metrics)
+	if len(client.hostMetrics) > hostMetricsHistorySize {
+		client.hostMetrics = client.hostMetrics[len(client.hostMetrics)-hostMetricsHistorySize:]
+	}
+	client.hostMetricsLock.Unlock()
+
+	client.infoLock.Lock()
+	client.Info.Metrics = &metrics
+	client.infoLock.Unlock()
+}
+
+// HostMetrics returns the samples of host metrics taken after the given time, oldest first
+func (client *Client) HostMetrics(since time.Time) models.HostMetricsHistory {
+	client.hostMetricsLock.Lock()
+	defer client.hostMetricsLock.Unlock()
+
+	history := models.HostMetricsHistory{ClientID: client.ID, Samples: []models.HostMetrics{}}
+	for _, metrics := range client.hostMetrics {
+		if metrics.Time.After(since) {
+			history.Samples = append(history.Samples, metrics)
+		}
+	}
+	return history
+}
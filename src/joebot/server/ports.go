@@ -0,0 +1,77 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+// portRangeOf returns the range the server ports of a client with the tags come from,
+// which is the range of its first tag having one, or the tunnel port range
+func (server *Server) portRangeOf(tags []string) utils.PortRange {
+	for _, tag := range tags {
+		if r, ok := server.TagPortRanges[tag]; ok {
+			return r
+		}
+	}
+	return server.TunnelPortRange
+}
+
+// Owner of the server ports of the gost tunnel services, the ports of the tunnels are owned by their client
+const gostPortOwner = "gost"
+
+// reserveSpecificPort reserves the server port for the owner if it is in the range
+func (server *Server) reserveSpecificPort(r utils.PortRange, port int, owner string) error {
+	if !r.Contains(port) {
+		return errors.Errorf("Port %d Is Out Of Range %s", port, r)
+	}
+	return server.portsManager.ReserveSpecificPort(port, owner)
+}
+
+// PortUsage lists the reserved server ports along with their use, and how full the port ranges are
+func (server *Server) PortUsage() models.PortUsage {
+	tunnels := map[int]models.PortLease{}
+	for _, client := range server.connectedClients() {
+		for _, t := range client.portTunnels() {
+			// The server port of a listening end belongs to the other end
+			if t.Listen {
+				continue
+			}
+			lease := models.PortLease{Usage: models.PortUsageTunnel, ClientID: client.ID, ClientPort: t.ClientPort, Protocol: t.Protocol, Name: t.Name}
+			lease.Port = t.ServerPort
+			tunnels[t.ServerPort] = lease
+			if t.RelayPort > 0 {
+				lease.Port, lease.Usage = t.RelayPort, models.PortUsageRelay
+				tunnels[t.RelayPort] = lease
+			}
+		}
+	}
+
+	usage := models.PortUsage{Ranges: []models.PortRangeUsage{}, Ports: []models.PortLease{}}
+	for _, l := range server.portsManager.Leases() {
+		lease, ok := tunnels[l.Port]
+		if l.Owner == gostPortOwner {
+			lease = models.PortLease{Port: l.Port, Usage: models.PortUsageGost}
+		} else if !ok {
+			lease = models.PortLease{Port: l.Port, Usage: models.PortUsageReserved, ClientID: l.Owner}
+		}
+		usage.Ports = append(usage.Ports, lease)
+	}
+
+	if !server.TunnelPortRange.IsZero() {
+		r := server.TunnelPortRange
+		usage.Ranges = append(usage.Ranges, models.PortRangeUsage{Range: r.String(), Size: r.Size(), InUse: server.portsManager.InUse(r)})
+	}
+	tags := []string{}
+	for tag := range server.TagPortRanges {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		r := server.TagPortRanges[tag]
+		usage.Ranges = append(usage.Ranges, models.PortRangeUsage{Tag: tag, Range: r.String(), Size: r.Size(), InUse: server.portsManager.InUse(r)})
+	}
+	return usage
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/pkg/errors"
+)
+
+// PrometheusContentType is the content type of the Prometheus text exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// promWriter writes metrics in the Prometheus text exposition format
+type promWriter struct {
+	w *bufio.Writer
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (p *promWriter) header(name string, metricType string, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes a value of the metric, the labels are given as name and value pairs
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	p.w.WriteString(name)
+	if len(labels) > 0 {
+		p.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.w.WriteByte(',')
+			}
+			p.w.WriteString(labels[i] + `="` + labelValueEscaper.Replace(labels[i+1]) + `"`)
+		}
+		p.w.WriteByte('}')
+	}
+	p.w.WriteByte(' ')
+	p.w.WriteString(formatPromValue(value))
+	p.w.WriteByte('\n')
+}
+
+func formatPromValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// WritePrometheusMetrics writes the metrics of the server, its clients and tunnels in the Prometheus text format
+func (server *Server) WritePrometheusMetrics(w io.Writer) error {
+	p := &promWriter{w: bufio.NewWriter(w)}
+	server.writeClientMetrics(p)
+	server.writePortMetrics(p)
+	server.writeGostMetrics(p)
+	writeTaskMetrics(p)
+	server.writeTunnelTrafficMetrics(p)
+	return errors.Wrap(p.w.Flush(), "Failed To Write Metrics")
+}
+
+func (server *Server) writeClientMetrics(p *promWriter) {
+	infos := []models.ClientInfo{}
+	for _, client := range server.connectedClients() {
+		infos = append(infos, client.copyInfo())
+	}
+
+	statuses := map[string]int{models.ClientOnline: 0, models.ClientDegraded: 0}
+	for _, info := range infos {
+		statuses[info.Status]++
+	}
+	p.header("joebot_clients_connected", "gauge", "Number of connected clients by status.")
+	for _, status := range []string{models.ClientOnline, models.ClientDegraded} {
+		p.sample("joebot_clients_connected", float64(statuses[status]), "status", status)
+	}
+
+	p.header("joebot_client_tunnels", "gauge", "Number of tunnels of a connected client.")
+	for _, info := range infos {
+		p.sample("joebot_client_tunnels", float64(len(info.PortTunnels)), "client_id", info.ID, "host_name", info.HostName)
+	}
+	p.header("joebot_client_heartbeat_rtt_seconds", "gauge", "Round trip time of the last heartbeat of a connected client.")
+	for _, info := range infos {
+		if info.LastHeartbeat != nil {
+			p.sample("joebot_client_heartbeat_rtt_seconds", info.RTT/1000, "client_id", info.ID)
+		}
+	}
+	p.header("joebot_client_missed_heartbeats", "gauge", "Heartbeats missed in a row by a connected client.")
+	for _, info := range infos {
+		p.sample("joebot_client_missed_heartbeats", float64(info.MissedBeats), "client_id", info.ID)
+	}
+}
+
+func (server *Server) writePortMetrics(p *promWriter) {
+	usage := server.PortUsage()
+
+	leased := map[string]int{models.PortUsageGost: 0, models.PortUsageTunnel: 0, models.PortUsageRelay: 0, models.PortUsageReserved: 0}
+	for _, lease := range usage.Ports {
+		leased[lease.Usage]++
+	}
+	p.header("joebot_ports_leased", "gauge", "Number of server ports leased by the ports manager by usage.")
+	for _, u := range []string{models.PortUsageGost, models.PortUsageTunnel, models.PortUsageRelay, models.PortUsageReserved} {
+		p.sample("joebot_ports_leased", float64(leased[u]), "usage", u)
+	}
+
+	p.header("joebot_port_range_size", "gauge", "Number of ports of a server port range.")
+	for _, r := range usage.Ranges {
+		p.sample("joebot_port_range_size", float64(r.Size), "range", r.Range, "tag", r.Tag)
+	}
+	p.header("joebot_port_range_in_use", "gauge", "Number of leased ports of a server port range.")
+	for _, r := range usage.Ranges {
+		p.sample("joebot_port_range_in_use", float64(r.InUse), "range", r.Range, "tag", r.Tag)
+	}
+}
+
+func (server *Server) writeGostMetrics(p *promWriter) {
+	tunnels := map[int]int{}
+	for _, client := range server.connectedClients() {
+		for _, t := range client.portTunnels() {
+			if t.DataPlane == models.DataPlaneGost && t.GostServerPort > 0 {
+				tunnels[t.GostServerPort]++
+			}
+		}
+	}
+	gostTunnels := server.gostTunnels
+
+	p.header("joebot_gost_services", "gauge", "Number of gost tunnel services in the pool.")
+	p.sample("joebot_gost_services", float64(len(gostTunnels)))
+	p.header("joebot_gost_service_tunnels", "gauge", "Number of tunnels going through a gost tunnel service.")
+	for _, g := range gostTunnels {
+		p.sample("joebot_gost_service_tunnels", float64(tunnels[g.Port]), "port", strconv.Itoa(g.Port))
+	}
+	p.header("joebot_gost_service_busy", "gauge", "Whether a gost tunnel service is creating a tunnel, or throttled since the last one.")
+	for _, g := range gostTunnels {
+		busy := 0.0
+		if g.Busy() {
+			busy = 1
+		}
+		p.sample("joebot_gost_service_busy", busy, "port", strconv.Itoa(g.Port))
+	}
+}
+
+func writeTaskMetrics(p *promWriter) {
+	stats := task.Stats()
+
+	p.header("joebot_task_requests_total", "counter", "Number of tasks requested or handled by the server by type.")
+	for _, stat := range stats {
+		p.sample("joebot_task_requests_total", float64(stat.Count), "type", stat.Type.String(), "direction", stat.Direction)
+	}
+	p.header("joebot_task_failures_total", "counter", "Number of failed tasks by type.")
+	for _, stat := range stats {
+		p.sample("joebot_task_failures_total", float64(stat.Failures), "type", stat.Type.String(), "direction", stat.Direction)
+	}
+
+	p.header("joebot_task_duration_seconds", "histogram", "Duration of the tasks until their first response.")
+	for _, stat := range stats {
+		labels := []string{"type", stat.Type.String(), "direction", stat.Direction}
+		cumulative := uint64(0)
+		for i, bound := range task.DurationBuckets {
+			cumulative += stat.Buckets[i]
+			p.sample("joebot_task_duration_seconds_bucket", float64(cumulative), append(labels, "le", formatPromValue(bound))...)
+		}
+		p.sample("joebot_task_duration_seconds_bucket", float64(stat.Observed), append(labels, "le", "+Inf")...)
+		p.sample("joebot_task_duration_seconds_sum", stat.DurationSum, labels...)
+		p.sample("joebot_task_duration_seconds_count", float64(stat.Observed), labels...)
+	}
+}
+
+func (server *Server) writeTunnelTrafficMetrics(p *promWriter) {
+	type tunnelTraffic struct {
+		labels []string
+		TunnelTraffic
+	}
+	clients := server.connectedClients()
+	traffic := []tunnelTraffic{}
+	for _, client := range clients {
+		for _, t := range client.portTunnels() {
+			// The server port of a listening end belongs to the other end
+			if t.Listen && t.PeerClientID != "" {
+				continue
+			}
+			tt := tunnelTraffic{TunnelTraffic: client.tunnelTraffic(t)}
+			tt.labels = []string{"client_id", client.ID, "client_port", strconv.Itoa(t.ClientPort), "protocol", t.Protocol, "server_port", strconv.Itoa(t.ServerPort), "name", t.Name}
+			traffic = append(traffic, tt)
+		}
+	}
+	sort.SliceStable(traffic, func(i, j int) bool {
+		return strings.Join(traffic[i].labels, ",") < strings.Join(traffic[j].labels, ",")
+	})
+
+	p.header("joebot_client_received_bytes_total", "counter", "Bytes received from the connections to the tunnels of a connected client.")
+	for _, client := range clients {
+		p.sample("joebot_client_received_bytes_total", float64(client.bandwidth.Traffic().Received), "client_id", client.ID)
+	}
+	p.header("joebot_client_sent_bytes_total", "counter", "Bytes sent to the connections to the tunnels of a connected client.")
+	for _, client := range clients {
+		p.sample("joebot_client_sent_bytes_total", float64(client.bandwidth.Traffic().Sent), "client_id", client.ID)
+	}
+
+	p.header("joebot_tunnel_received_bytes_total", "counter", "Bytes received from the connections to the server port of a tunnel.")
+	for _, tt := range traffic {
+		p.sample("joebot_tunnel_received_bytes_total", float64(tt.Received), tt.labels...)
+	}
+	p.header("joebot_tunnel_sent_bytes_total", "counter", "Bytes sent to the connections to the server port of a tunnel.")
+	for _, tt := range traffic {
+		p.sample("joebot_tunnel_sent_bytes_total", float64(tt.Sent), tt.labels...)
+	}
+}
+
+// Ready tells whether the server accepts clients and creates tunnels
+func (server *Server) Ready() error {
+	if server.ctx.Err() != nil {
+		return errors.New("Server Is Stopped")
+	}
+	if server.tcpListener == nil {
+		return errors.New("Server Is Not Listening For Clients")
+	}
+	if server.dataPlane() == models.DataPlaneGost && len(server.gostTunnels) == 0 {
+		return errors.New("Gost Tunnel Services Are Not Started")
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/pkg/errors"
+	"github.com/twinj/uuid"
+)
+
+// maxRecordingHeaderSize bounds the first line of a recording, which holds its asciicast header
+const maxRecordingHeaderSize = 64 * 1024
+
+// RecordingStore keeps the terminal recordings as cast files in the data directory, indexed in the registry database
+type RecordingStore struct {
+	dir string
+	db  storm.Node
+}
+
+func NewRecordingStore(registry *Registry, dir string) *RecordingStore {
+	return &RecordingStore{dir: dir, db: registry.db.From("recordings")}
+}
+
+// Save writes the cast of a recording as it is read, so that a recording is never held in memory as a whole
+func (store *RecordingStore) Save(clientID string, recording models.TerminalRecording, cast io.Reader) error {
+	// The ID is used as file name, so only UUIDs are accepted
+	if _, err := uuid.Parse(recording.ID); err != nil {
+		return errors.New("Invalid recording ID: " + recording.ID)
+	}
+	if _, err := store.Get(recording.ID); err == nil {
+		return errors.New("Recording already exists: " + recording.ID)
+	}
+
+	header := struct {
+		Timestamp int64 `json:"timestamp"`
+	}{}
+	reader := bufio.NewReaderSize(cast, maxRecordingHeaderSize)
+	firstLine, err := reader.ReadSlice('\n')
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "Failed To Read Asciicast Header")
+	}
+	if err := json.Unmarshal(firstLine, &header); err != nil {
+		return errors.Wrap(err, "Invalid asciicast header")
+	}
+
+	recording.ClientID = clientID
+	recording.StartedAt = time.Unix(header.Timestamp, 0)
+	if err := os.MkdirAll(filepath.Dir(store.Path(recording)), 0700); err != nil {
+		return err
+	}
+	// The recording only shows up under its path once complete
+	partPath := store.Path(recording) + ".part"
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Failed To Save Recording")
+	}
+	size, err := file.Write(firstLine)
+	if err == nil {
+		var n int64
+		n, err = io.Copy(file, reader)
+		size += int(n)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partPath, store.Path(recording))
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return errors.Wrap(err, "Failed To Save Recording")
+	}
+
+	recording.Size = size
+	return errors.Wrap(store.db.Save(&recording), "Failed To Index Recording")
+}
+
+func (store *RecordingStore) Get(id string) (models.TerminalRecording, error) {
+	var recording models.TerminalRecording
+	if err := store.db.One("ID", id, &recording); err != nil {
+		return recording, errors.New("Recording Not Found: " + id)
+	}
+	return recording, nil
+}
+
+// List returns the recordings of a client, latest first
+func (store *RecordingStore) List(clientID string) ([]models.TerminalRecording, error) {
+	recordings := []models.TerminalRecording{}
+	err := store.db.Find("ClientID", clientID, &recordings)
+	if err == storm.ErrNotFound {
+		return recordings, nil
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].StartedAt.After(recordings[j].StartedAt)
+	})
+	return recordings, err
+}
+
+func (store *RecordingStore) Path(recording models.TerminalRecording) string {
+	return filepath.Join(store.dir, recording.ClientID, recording.ID+".cast")
+}
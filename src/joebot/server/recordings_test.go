@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/twinj/uuid"
+)
+
+// streamRecording sends the cast in chunks followed by an empty one, as the client uploads recordings
+func streamRecording(conn net.Conn, cast []byte, chunkSize int) {
+	for len(cast) > 0 {
+		n := chunkSize
+		if n > len(cast) {
+			n = len(cast)
+		}
+		if task.SendObject(cast[:n], conn, time.Second) != nil {
+			return
+		}
+		cast = cast[n:]
+	}
+	task.SendObject(nil, conn, time.Second)
+}
+
+func TestSaveStreamedRecording(t *testing.T) {
+	registry, err := NewRegistry(filepath.Join(t.TempDir(), "joebot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Close()
+	store := NewRecordingStore(registry, t.TempDir())
+
+	cast := []byte(`{"version": 2, "width": 80, "height": 24, "timestamp": 1700000000}` + "\n" +
+		strings.Repeat(`[0.5, "o", "hello world\r\n"]`+"\n", 10000))
+	large := append(append([]byte{}, cast...), bytes.Repeat([]byte(`[1, "o", "."]`+"\n"), maxRecordingChunkSize/10)...)
+	tests := []struct {
+		name      string
+		cast      []byte
+		chunkSize int
+		saved     bool
+	}{
+		{"small chunks", cast, 1000, true},
+		{"single chunk", cast, len(cast), true},
+		{"header only", cast[:bytes.IndexByte(cast, '\n')+1], 7, true},
+		{"invalid header", []byte("not a cast\n" + string(cast)), 1000, false},
+		{"chunk above the limit", large, len(large), false},
+	}
+	for _, tt := range tests {
+		conn, peer := net.Pipe()
+		go streamRecording(peer, tt.cast, tt.chunkSize)
+
+		recording := models.TerminalRecording{ID: uuid.NewV4().String(), EndedAt: time.Now()}
+		err := store.Save("client", recording, &recordingReader{stream: conn})
+		conn.Close()
+		peer.Close()
+		if !tt.saved {
+			if err == nil {
+				t.Errorf("%s: recording was saved", tt.name)
+			}
+			if _, err := store.Get(recording.ID); err == nil {
+				t.Errorf("%s: recording was indexed", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		saved, err := store.Get(recording.ID)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if saved.Size != len(tt.cast) || saved.ClientID != "client" || saved.StartedAt.Unix() != 1700000000 {
+			t.Errorf("%s: saved recording: %+v", tt.name, saved)
+		}
+		if content, err := ioutil.ReadFile(store.Path(saved)); err != nil || !bytes.Equal(content, tt.cast) {
+			t.Errorf("%s: content of the saved recording differs (%v)", tt.name, err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(store.dir, "client", "*.part"))
+	if len(files) > 0 {
+		t.Errorf("partial recordings left: %v", files)
+	}
+}
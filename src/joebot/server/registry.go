@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/codec/gob"
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/pkg/errors"
+)
+
+// Maximum number of connect/disconnect events kept per client
+const maxConnectionHistory = 100
+
+// Registry persists the clients ever seen by the server, so that the inventory
+// survives restarts and offline clients can still be listed
+type Registry struct {
+	db   *storm.DB
+	lock sync.Mutex
+}
+
+func NewRegistry(path string) (*Registry, error) {
+	// Gob is used rather than JSON, so that fields hidden from the API (eg: secret hashes) are still persisted
+	db, err := storm.Open(path, storm.Codec(gob.Codec))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed To Open Client Registry: "+path)
+	}
+
+	return &Registry{db: db}, nil
+}
+
+func (r *Registry) Close() error {
+	return r.db.Close()
+}
+
+func (r *Registry) Get(id string) (models.ClientRecord, error) {
+	var record models.ClientRecord
+	err := r.db.One("ID", id, &record)
+	if err == storm.ErrNotFound {
+		return record, errors.New("Client Record Not Found: " + id)
+	}
+	return record, err
+}
+
+func (r *Registry) All() ([]models.ClientRecord, error) {
+	records := []models.ClientRecord{}
+	err := r.db.All(&records)
+	if err == storm.ErrNotFound {
+		return records, nil
+	}
+	return records, err
+}
+
+func (r *Registry) Delete(id string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.db.DeleteStruct(&models.ClientRecord{ID: id})
+}
+
+// RecordConnect creates the client record if needed and appends a connect event
+func (r *Registry) RecordConnect(info models.ClientInfo, ip string) (models.ClientRecord, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	record, err := r.Get(info.ID)
+	if err != nil {
+		record = models.ClientRecord{ID: info.ID, Info: info}
+		record.Info.FirstSeen = now
+	}
+	record.Info.LastSeen = now
+	record.History = appendConnectionEvent(record.History, models.ConnectionEvent{Type: models.ClientConnected, Time: now, IP: ip})
+
+	return record, r.db.Save(&record)
+}
+
+// RecordDisconnect appends a disconnect event and updates the last seen time
+func (r *Registry) RecordDisconnect(id string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	record, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	record.Info.Online = false
+	record.Info.Status = models.ClientOffline
+	record.Info.LastSeen = now
+	record.History = appendConnectionEvent(record.History, models.ConnectionEvent{Type: models.ClientDisconnected, Time: now, IP: record.Info.IP})
+
+	return r.db.Save(&record)
+}
+
+// SaveInfo stores the latest known info (host details, tunnels) of a connected client
+func (r *Registry) SaveInfo(info models.ClientInfo) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	record, err := r.Get(info.ID)
+	if err != nil {
+		record = models.ClientRecord{ID: info.ID}
+		info.FirstSeen = time.Now()
+	} else {
+		info.FirstSeen = record.Info.FirstSeen
+	}
+	info.LastSeen = time.Now()
+	record.Info = info
+
+	return r.db.Save(&record)
+}
+
+// Update applies the given changes to the client record, creating the record if needed
+func (r *Registry) Update(id string, update func(record *models.ClientRecord)) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	record, err := r.Get(id)
+	if err != nil {
+		record = models.ClientRecord{ID: id, Info: models.ClientInfo{ID: id}}
+		record.Info.FirstSeen = time.Now()
+	}
+	update(&record)
+
+	return r.db.Save(&record)
+}
+
+func appendConnectionEvent(history []models.ConnectionEvent, event models.ConnectionEvent) []models.ConnectionEvent {
+	history = append(history, event)
+	if len(history) > maxConnectionHistory {
+		history = history[len(history)-maxConnectionHistory:]
+	}
+	return history
+}
+
+func hashSecret(secret string) string {
+	digest := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(digest[:])
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// targetRule allows the server-side targets matching a host name pattern, an IP address or a network, on some ports
+type targetRule struct {
+	host    string
+	network *net.IPNet
+	ports   [][2]int
+}
+
+// TargetAllowlist lists the server-side addresses which reverse tunnels may connect to
+type TargetAllowlist []targetRule
+
+// ParseTargetAllowlist parses entries in the form HOST:PORTS, where HOST is a host name pattern such as *.corp, an IP
+// address or a CIDR, and PORTS is * or a comma separated list of ports or port ranges, eg: 10.0.0.0/8:3128,8000-8100
+func ParseTargetAllowlist(entries []string) (TargetAllowlist, error) {
+	allowlist := TargetAllowlist{}
+	for _, entry := range entries {
+		colon := strings.LastIndex(entry, ":")
+		if colon <= 0 {
+			return nil, errors.New("Invalid Reverse Tunnel Allowlist Entry, HOST:PORTS Expected: " + entry)
+		}
+		host, ports := strings.Trim(entry[:colon], "[]"), entry[colon+1:]
+
+		rule := targetRule{host: strings.ToLower(host)}
+		if strings.Contains(host, "/") {
+			_, network, err := net.ParseCIDR(host)
+			if err != nil {
+				return nil, errors.Wrap(err, "Invalid Reverse Tunnel Allowlist Entry: "+entry)
+			}
+			rule.network = network
+		} else if _, err := path.Match(rule.host, ""); err != nil {
+			return nil, errors.Wrap(err, "Invalid Reverse Tunnel Allowlist Entry: "+entry)
+		}
+
+		if ports == "*" {
+			rule.ports = [][2]int{{1, 65535}}
+		} else {
+			for _, r := range strings.Split(ports, ",") {
+				bounds := strings.SplitN(r, "-", 2)
+				lbound, err := strconv.Atoi(bounds[0])
+				ubound := lbound
+				if err == nil && len(bounds) == 2 {
+					ubound, err = strconv.Atoi(bounds[1])
+				}
+				if err != nil || lbound <= 0 || ubound > 65535 || lbound > ubound {
+					return nil, errors.New("Invalid Reverse Tunnel Allowlist Port Range: " + entry)
+				}
+				rule.ports = append(rule.ports, [2]int{lbound, ubound})
+			}
+		}
+		allowlist = append(allowlist, rule)
+	}
+	return allowlist, nil
+}
+
+// Allows tells if the target address, in the form host:port, is in the allowlist
+func (allowlist TargetAllowlist) Allows(address string) bool {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, rule := range allowlist {
+		matched := false
+		for _, r := range rule.ports {
+			matched = matched || (port >= r[0] && port <= r[1])
+		}
+		if !matched {
+			continue
+		}
+		if rule.network != nil {
+			if ip != nil && rule.network.Contains(ip) {
+				return true
+			}
+		} else if ruleIP := net.ParseIP(rule.host); ruleIP != nil {
+			if ip != nil && ruleIP.Equal(ip) {
+				return true
+			}
+		} else if ok, _ := path.Match(rule.host, host); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package server
+
+import "testing"
+
+func TestParseTargetAllowlistInvalid(t *testing.T) {
+	for _, entry := range []string{
+		"",
+		"10.0.0.1",
+		":22",
+		"10.0.0.1:",
+		"10.0.0.1:ssh",
+		"10.0.0.1:0",
+		"10.0.0.1:65536",
+		"10.0.0.1:-22",
+		"10.0.0.1:22-",
+		"10.0.0.1:100-20",
+		"10.0.0.1:22,",
+		"10.0.0.0/33:22",
+		"10.0.0.0/8/8:22",
+		"a[b:22",
+	} {
+		if _, err := ParseTargetAllowlist([]string{entry}); err == nil {
+			t.Errorf("entry %q was accepted", entry)
+		}
+	}
+}
+
+func TestTargetAllowlistAllows(t *testing.T) {
+	allowlist, err := ParseTargetAllowlist([]string{
+		"10.0.0.0/8:3128,8000-8100",
+		"192.168.1.10:22",
+		"*.Corp:443",
+		"proxy.example.com:*",
+		"[fd00::1]:22",
+		"fe80::/10:80",
+		"2001:db8::5:25",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		// CIDR
+		{"10.1.2.3:3128", true},
+		{"10.1.2.3:8000", true},
+		{"10.1.2.3:8050", true},
+		{"10.1.2.3:8100", true},
+		{"10.1.2.3:7999", false},
+		{"10.1.2.3:8101", false},
+		{"10.1.2.3:22", false},
+		{"11.0.0.1:3128", false},
+		{"[::ffff:10.1.2.3]:3128", true},
+		// Exact IP
+		{"192.168.1.10:22", true},
+		{"192.168.1.11:22", false},
+		{"192.168.1.10:23", false},
+		// Glob, case insensitive
+		{"git.corp:443", true},
+		{"GIT.CORP:443", true},
+		{"a.b.corp:443", true},
+		{"corp:443", false},
+		{"git.corp.evil.com:443", false},
+		{"git.corp:80", false},
+		// Any port
+		{"proxy.example.com:1", true},
+		{"proxy.example.com:65535", true},
+		{"other.example.com:3128", false},
+		// IPv6
+		{"[fd00::1]:22", true},
+		{"[fd00:0::1]:22", true},
+		{"[fd00::2]:22", false},
+		{"[fe80::1234]:80", true},
+		{"[fe80::1234]:81", false},
+		{"[2001:db8::5]:25", true},
+		// Host names are not resolved against IP rules
+		{"localhost:22", false},
+		// Malformed addresses
+		{"10.1.2.3", false},
+		{"10.1.2.3:http", false},
+		{"fd00::1:22", false},
+	}
+	for _, tt := range tests {
+		if allowed := allowlist.Allows(tt.address); allowed != tt.allowed {
+			t.Errorf("%s allowed: %v, expected: %v", tt.address, allowed, tt.allowed)
+		}
+	}
+
+	if (TargetAllowlist{}).Allows("10.1.2.3:3128") {
+		t.Error("empty allowlist allowed a target")
+	}
+}
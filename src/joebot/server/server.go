@@ -2,16 +2,22 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"math/rand"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/asdine/storm"
 	"github.com/harmonicinc-com/joebot/models"
 	"github.com/harmonicinc-com/joebot/sshconnect"
+	"github.com/harmonicinc-com/joebot/task"
 	"github.com/harmonicinc-com/joebot/utils"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -21,9 +27,59 @@ import (
 type Server struct {
 	logger *logrus.Logger
 
-	portsManager *utils.PortsManager
-	gostTunnels  []*GostTunnel
-	tcpListener  net.Listener
+	DataDir  string
+	registry *Registry
+
+	// TLSEnabled secures the control channel with certificates issued by the server CA
+	TLSEnabled bool
+	ca         *CertificateAuthority
+
+	// RequireToken only allows new clients presenting a valid enrollment token to join
+	RequireToken bool
+	tokens       *TokenStore
+
+	users    *UserStore
+	sessions *SessionStore
+	audit    *AuditLog
+
+	recordings *RecordingStore
+
+	// Key pair of the gost tunnel services, generated in the data directory unless specified
+	GostCertFile           string
+	GostKeyFile            string
+	gostHostKeyFingerprint string
+
+	// TunnelBindAddress is the address the tunnels listen on, all interfaces if empty.
+	// Binding to 127.0.0.1 leaves the web portal reverse proxy as the only way in
+	TunnelBindAddress string
+	// DataPlane carries the connections of the tunnels, either through the pool of gost tunnel services (default),
+	// or through the yamux session of the control channel
+	DataPlane string
+	// TunnelPortRange is where the gost tunnel services and the tunnels get their server ports from, any free port if zero
+	TunnelPortRange utils.PortRange
+	// TagPortRanges replaces the tunnel port range for the clients having the tag
+	TagPortRanges map[string]utils.PortRange
+	// ReverseTunnelAllowlist lists the server-side targets reverse tunnels may connect to, none if empty
+	ReverseTunnelAllowlist TargetAllowlist
+
+	// HeartbeatInterval is the time between the pings of the clients, heartbeats are disabled if zero
+	HeartbeatInterval time.Duration
+	// HeartbeatMaxMissed is the number of heartbeats missed in a row before a client is evicted, never if zero
+	HeartbeatMaxMissed int
+	// DegradedRTT is the round trip time above which a client is degraded
+	DegradedRTT time.Duration
+
+	portsManager      *utils.PortsManager
+	gostTunnels       []*GostTunnel
+	tunnelCredentials *TunnelCredentials
+	tunnelActivity    *TunnelActivity
+	tcpListener       net.Listener
+
+	// Rate limits of the clients and tags by ID, and the bandwidths of the tags having one
+	rateLimits    map[string]models.RateLimit
+	rateLimitsDB  storm.Node
+	tagBandwidths map[string]*Bandwidth
+	bandwidthLock sync.Mutex
 
 	sync.RWMutex         // Mutex lock for creating tunnel
 	gostTunnelStartIndex int
@@ -44,7 +100,16 @@ func NewServer(logger *logrus.Logger) *Server {
 	server.logger = logger
 	server.portsManager = utils.NewPortsManager()
 	server.gostTunnels = []*GostTunnel{}
+	server.tunnelCredentials = NewTunnelCredentials()
+	server.tunnelActivity = NewTunnelActivity()
+	server.tunnelActivity.accounts = server.accountsOf
+	server.rateLimits = map[string]models.RateLimit{}
+	server.tagBandwidths = map[string]*Bandwidth{}
+	server.sessions = NewSessionStore()
 	server.gostTunnelStartIndex = 0
+	server.HeartbeatInterval = DefaultHeartbeatInterval
+	server.HeartbeatMaxMissed = DefaultHeartbeatMaxMissed
+	server.DegradedRTT = DefaultDegradedRTT
 
 	server.clientsListLock = make(chan bool, 1)
 	server.clientsListLock <- true
@@ -75,20 +140,85 @@ func (server *Server) RemoveClient(clientID string) (*Client, error) {
 		server.logger.Error(err)
 	} else {
 		server.logger.Info("Server Removed Client With ID: " + clientID)
+		if server.registry != nil {
+			if err := server.registry.RecordDisconnect(clientID); err != nil {
+				server.logger.Error(errors.Wrap(err, "Failed To Record Client Disconnection"))
+			}
+		}
 	}
 	return result, err
 }
 
+// connectedClients returns a snapshot of the connected clients, which can be iterated without holding clientsListLock
+func (server *Server) connectedClients() []*Client {
+	<-server.clientsListLock
+	defer func() { server.clientsListLock <- true }()
+
+	return append([]*Client{}, server.clients...)
+}
+
+// GetClientsList returns the connected clients followed by the offline clients known by the registry
 func (server *Server) GetClientsList() models.ClientCollection {
 	var clientCollection models.ClientCollection
 	clientCollection.Clients = []models.ClientInfo{}
+	onlineClientIDs := map[string]bool{}
 	for _, client := range server.clients {
-		clientCollection.Clients = append(clientCollection.Clients, client.Info)
+		clientCollection.Clients = append(clientCollection.Clients, client.GetInfo())
+		onlineClientIDs[client.ID] = true
+	}
+
+	if server.registry != nil {
+		records, err := server.registry.All()
+		if err != nil {
+			server.logger.Error(errors.Wrap(err, "Failed To Load Clients From Registry"))
+		}
+		for _, record := range records {
+			if !onlineClientIDs[record.ID] {
+				info := record.Info
+				info.Online = false
+				info.Status = models.ClientOffline
+				clientCollection.Clients = append(clientCollection.Clients, info)
+			}
+		}
 	}
 
 	return clientCollection
 }
 
+// GetClientRecord returns the persisted record, including the connection history, of a client
+func (server *Server) GetClientRecord(id string) (models.ClientRecord, error) {
+	if server.registry == nil {
+		client, err := server.GetClientById(id)
+		if err != nil {
+			return models.ClientRecord{}, err
+		}
+		return models.ClientRecord{ID: client.ID, Info: client.GetInfo()}, nil
+	}
+
+	record, err := server.registry.Get(id)
+	if err != nil {
+		return record, err
+	}
+	if client, err := server.GetClientById(id); err == nil {
+		record.Info = client.GetInfo()
+	}
+	return record, nil
+}
+
+// ForgetClient removes an offline client from the registry
+func (server *Server) ForgetClient(id string) error {
+	if _, err := server.GetClientById(id); err == nil {
+		return errors.New("Unable To Forget Connected Client: " + id)
+	}
+	if server.registry == nil {
+		return errors.New("Client Registry Is Not Enabled")
+	}
+	if _, err := server.registry.Get(id); err != nil {
+		return err
+	}
+	return server.registry.Delete(id)
+}
+
 func (server *Server) GetClientById(id string) (*Client, error) {
 	for _, client := range server.clients {
 		if client.ID == id {
@@ -102,6 +232,25 @@ func (server *Server) AddClient(client *Client) {
 	<-server.clientsListLock
 	defer func() { server.clientsListLock <- true }()
 
+	client.infoLock.Lock()
+	client.Info.Online = true
+	client.Info.Status = models.ClientOnline
+	client.infoLock.Unlock()
+	if server.registry != nil {
+		var ip string
+		if client.conn != nil {
+			ip, _, _ = net.SplitHostPort((*client.conn).RemoteAddr().String())
+		}
+		record, err := server.registry.RecordConnect(client.copyInfo(), ip)
+		if err != nil {
+			server.logger.Error(errors.Wrap(err, "Failed To Record Client Connection"))
+		}
+		client.infoLock.Lock()
+		client.Info.FirstSeen = record.Info.FirstSeen
+		client.Info.LastSeen = record.Info.LastSeen
+		client.infoLock.Unlock()
+	}
+
 	server.clients = append(server.clients, client)
 	server.logger.Info("Server Added Client With ID: " + client.ID)
 }
@@ -113,10 +262,22 @@ func (server *Server) Stop() error {
 		server.RemoveClient(client.ID)
 	}
 	server.gostTunnels = []*GostTunnel{}
+	server.portsManager.ReleaseOwner(gostPortOwner)
 
+	if server.registry != nil {
+		server.registry.Close()
+	}
 	return server.tcpListener.Close()
 }
 
+// dataPlane returns the data plane of the tunnels, gost unless yamux is set
+func (server *Server) dataPlane() string {
+	if server.DataPlane == models.DataPlaneYamux {
+		return models.DataPlaneYamux
+	}
+	return models.DataPlaneGost
+}
+
 func (server *Server) GetTunnelService() *GostTunnel {
 	server.Lock()
 	defer server.Unlock()
@@ -133,15 +294,54 @@ func (server *Server) GetTunnelService() *GostTunnel {
 func (server *Server) Start(port int) error {
 	var err error
 
-	//Setup 100 Gost SSH Tunnel Services
-	for i := 0; i < 30; i++ {
-		freePort, err := server.portsManager.ReservePort()
+	if server.DataDir != "" {
+		if err = os.MkdirAll(server.DataDir, 0700); err != nil {
+			err = errors.Wrap(err, "Unable to create data directory")
+			server.logger.Error(err)
+			return err
+		}
+		server.registry, err = NewRegistry(filepath.Join(server.DataDir, "joebot.db"))
+		if err != nil {
+			server.logger.Error(err)
+			return err
+		}
+		server.tokens = NewTokenStore(server.registry)
+		server.users = NewUserStore(server.registry)
+		server.audit = NewAuditLog(server.registry)
+		server.recordings = NewRecordingStore(server.registry, filepath.Join(server.DataDir, "recordings"))
+		server.rateLimitsDB = server.registry.db.From("rate_limits")
+		if err = server.loadRateLimits(); err != nil {
+			server.logger.Error(err)
+			return err
+		}
+	} else if server.RequireToken {
+		err = errors.New("Data directory is required for storing enrollment tokens")
+		server.logger.Error(err)
+		return err
+	}
+
+	gostCert, err := LoadOrCreateGostCertificate(server.DataDir, server.GostCertFile, server.GostKeyFile)
+	if err != nil {
+		server.logger.Error(err)
+		return err
+	}
+	server.gostHostKeyFingerprint, err = GostHostKeyFingerprint(gostCert)
+	if err != nil {
+		server.logger.Error(err)
+		return err
+	}
+	server.logger.Info("Gost Tunnel Host Key Fingerprint: " + server.gostHostKeyFingerprint)
+
+	//Setup 100 Gost SSH Tunnel Services, which are not used by the yamux data plane
+	for i := 0; i < 30 && server.dataPlane() == models.DataPlaneGost; i++ {
+		freePort, err := server.portsManager.ReservePortInRange(server.TunnelPortRange, gostPortOwner)
 		if err != nil {
 			err = errors.Wrap(err, "Unable to find port for Gost Tunnel Server")
 			server.logger.Error(err)
 			return err
 		}
-		gostTunnel := NewGostTunnel(freePort)
+		gostTunnel := NewGostTunnel(freePort, gostCert, server.tunnelCredentials)
+		gostTunnel.activity = server.tunnelActivity
 		server.gostTunnels = append(server.gostTunnels, gostTunnel)
 		go func(server *Server, gostTunnel *GostTunnel) {
 			server.logger.Info("Starting Gost Reverse Tunnel On Port: " + strconv.Itoa(gostTunnel.Port))
@@ -155,12 +355,28 @@ func (server *Server) Start(port int) error {
 		}(server, gostTunnel)
 	}
 
+	go server.expireTunnels()
+
 	server.tcpListener, err = net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
 		err = errors.Wrap(err, "Unable to start server")
 		server.logger.Error(err)
 		return err
 	}
+	if server.TLSEnabled {
+		if server.DataDir == "" {
+			err = errors.New("Data directory is required for storing the certificate authority")
+			server.logger.Error(err)
+			return err
+		}
+		server.ca, err = LoadOrCreateCertificateAuthority(server.DataDir)
+		if err != nil {
+			server.logger.Error(err)
+			return err
+		}
+		server.tcpListener = tls.NewListener(server.tcpListener, server.ca.TLSConfig())
+		server.logger.Info("TLS Enabled | CA Fingerprint: " + server.ca.Fingerprint())
+	}
 
 	go func() {
 		for {
@@ -176,10 +392,7 @@ func (server *Server) Start(port int) error {
 					continue
 				}
 
-				client := NewClient(uuid.NewV4().String(), server, &conn, server.logger)
-				server.AddClient(client)
-
-				go func(client *Client) { client.Start() }(client)
+				go server.handleConnection(conn)
 			}
 		}
 	}()
@@ -187,6 +400,327 @@ func (server *Server) Start(port int) error {
 	return nil
 }
 
+// maxHandshakeSize bounds the handshake of a connecting client, which only carries its identity and credentials
+const maxHandshakeSize = 8 << 10
+
+// handleConnection performs the handshake with a newly connected client and binds it to its identity
+func (server *Server) handleConnection(conn net.Conn) {
+	var peerCertificate *x509.Certificate
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+		if err := tlsConn.Handshake(); err != nil {
+			server.logger.Error(errors.Wrap(err, "TLS Handshake Failed With "+conn.RemoteAddr().String()))
+			conn.Close()
+			return
+		}
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			peerCertificate = certs[0]
+		}
+	}
+
+	// The peer is not authenticated yet, so the size of what it sends is bounded
+	body, err := task.ReceiveLimitedObject(conn, 10*time.Second, maxHandshakeSize)
+	if err != nil {
+		server.logger.Error(errors.Wrap(err, "Failed To Receive Handshake From "+conn.RemoteAddr().String()))
+		conn.Close()
+		return
+	}
+	var handshake models.Handshake
+	if err = utils.BytesToStruct(body, &handshake); err != nil {
+		server.logger.Error(errors.Wrap(err, "Unable to decode handshake from "+conn.RemoteAddr().String()))
+		conn.Close()
+		return
+	}
+
+	result := server.authenticate(handshake, peerCertificate)
+	if result.Accepted {
+		result.GostHostKeyFingerprint = server.gostHostKeyFingerprint
+	}
+	if err = task.SendObject(utils.StructToBytes(result), conn, 10*time.Second); err != nil {
+		server.logger.Error(errors.Wrap(err, "Failed To Send Handshake Result To "+conn.RemoteAddr().String()))
+		conn.Close()
+		return
+	}
+	if !result.Accepted {
+		server.logger.Error("Rejected Connection From " + conn.RemoteAddr().String() + ": " + result.Message)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	clientID := result.ClientID
+	if _, err := server.GetClientById(clientID); err == nil {
+		server.logger.Info("Client Reconnected, Dropping Stale Session With ID: " + clientID)
+		server.RemoveClient(clientID)
+	}
+
+	client := NewClient(clientID, server, &conn, server.logger)
+	if server.registry != nil {
+		if record, err := server.registry.Get(clientID); err == nil {
+			client.previousInfo = &record.Info
+			client.enrollmentTags = record.EnrollmentTags
+		}
+	}
+	client.setTags(handshake.Tags)
+	server.AddClient(client)
+
+	client.Start()
+}
+
+// authenticate resolves the identity of a connecting client.
+// Over TLS, the identity comes from the client certificate, and clients without certificate get enrolled
+func (server *Server) authenticate(handshake models.Handshake, peerCertificate *x509.Certificate) models.HandshakeResult {
+	reject := func(message string) models.HandshakeResult {
+		return models.HandshakeResult{Accepted: false, Message: message}
+	}
+
+	if peerCertificate != nil {
+		clientID := peerCertificate.Subject.CommonName
+		if handshake.ClientID != clientID {
+			return reject("Client ID does not match the client certificate")
+		}
+		if server.registry != nil {
+			record, err := server.registry.Get(clientID)
+			if err != nil || record.CertificateFingerprint != utils.CertificateFingerprint(peerCertificate.Raw) {
+				return reject("Unknown client certificate")
+			}
+		}
+		return models.HandshakeResult{ClientID: clientID, Accepted: true}
+	}
+
+	// Clients without a valid identity get a new one, which they are expected to persist
+	clientID := handshake.ClientID
+	if _, err := uuid.Parse(clientID); clientID == "" || err != nil {
+		clientID = uuid.NewV4().String()
+	}
+	var record models.ClientRecord
+	hasRecord := false
+	if server.registry != nil {
+		if r, err := server.registry.Get(clientID); err == nil {
+			record, hasRecord = r, true
+		}
+	}
+
+	// Enrolled clients must present the credential issued at enrollment
+	if hasRecord && record.CertificateFingerprint != "" {
+		return reject("Client is already enrolled, its certificate is required")
+	}
+	if hasRecord && record.SecretHash != "" {
+		if hashSecret(handshake.Secret) != record.SecretHash {
+			return reject("Invalid client secret")
+		}
+		return models.HandshakeResult{ClientID: clientID, Accepted: true}
+	}
+	// Without a credential to check, the session of a connected client is not handed over to whoever presents its ID
+	if _, err := server.GetClientById(clientID); err == nil {
+		return reject("Client is connected, its secret is required")
+	}
+
+	result := models.HandshakeResult{ClientID: clientID, Accepted: true}
+	var token models.EnrollmentToken
+	if server.RequireToken || (handshake.Token != "" && !hasRecord) {
+		if server.tokens == nil {
+			return reject("Enrollment tokens are not enabled")
+		}
+		var err error
+		if token, err = server.tokens.Redeem(handshake.Token); err != nil {
+			return reject(err.Error())
+		}
+	}
+
+	var err error
+	if server.ca != nil {
+		if len(handshake.CSR) == 0 {
+			return reject("Certificate signing request is required for enrollment")
+		}
+		if result.Certificate, err = server.ca.IssueClientCertificate(clientID, handshake.CSR); err != nil {
+			return reject(err.Error())
+		}
+	} else if server.registry != nil {
+		// The secret is required on the next connections, so that the client cannot be impersonated
+		if result.Secret, err = generateSecret(); err != nil {
+			return reject("Failed to generate client secret")
+		}
+	}
+	if server.registry == nil || (result.Certificate == nil && result.Secret == "" && token.ID == "") {
+		return result
+	}
+
+	err = server.registry.Update(clientID, func(record *models.ClientRecord) {
+		if result.Certificate != nil {
+			record.CertificateFingerprint = utils.CertificateFingerprint(result.Certificate)
+		}
+		if result.Secret != "" {
+			record.SecretHash = hashSecret(result.Secret)
+		}
+		if len(token.Tags) > 0 {
+			record.EnrollmentTags = token.Tags
+		}
+	})
+	if err != nil {
+		server.logger.Error(errors.Wrap(err, "Failed To Record Client Enrollment"))
+		return reject("Failed to record client enrollment")
+	}
+
+	server.logger.Info("Enrolled Client With ID: " + clientID)
+	return result
+}
+
+// CreateEnrollmentToken issues a token for joining the server, a zero ttl means the token never expires
+func (server *Server) CreateEnrollmentToken(description string, singleUse bool, ttl time.Duration, tags []string) (models.EnrollmentToken, error) {
+	if server.tokens == nil {
+		return models.EnrollmentToken{}, errors.New("Enrollment tokens require a data directory")
+	}
+	return server.tokens.Create(description, singleUse, ttl, tags)
+}
+
+func (server *Server) GetEnrollmentTokens() ([]models.EnrollmentToken, error) {
+	if server.tokens == nil {
+		return []models.EnrollmentToken{}, nil
+	}
+	return server.tokens.List()
+}
+
+func (server *Server) RevokeEnrollmentToken(id string) error {
+	if server.tokens == nil {
+		return errors.New("Enrollment tokens require a data directory")
+	}
+	return server.tokens.Revoke(id)
+}
+
+// UsersEnabled tells whether the web portal requires authentication, which is the case once a user exists
+func (server *Server) UsersEnabled() bool {
+	if server.users == nil {
+		return false
+	}
+	count, err := server.users.Count()
+	if err != nil {
+		server.logger.Error(errors.Wrap(err, "Failed To Count Users"))
+		// Fail closed
+		return true
+	}
+	return count > 0
+}
+
+func (server *Server) AuthenticateUser(username string, password string) (models.User, error) {
+	if server.users == nil {
+		return models.User{}, errors.New("User accounts require a data directory")
+	}
+	return server.users.Authenticate(username, password)
+}
+
+func (server *Server) GetUsers() ([]models.User, error) {
+	if server.users == nil {
+		return []models.User{}, nil
+	}
+	return server.users.List()
+}
+
+// SaveUser creates or updates a user, the password is kept unchanged if empty
+func (server *Server) SaveUser(user models.User, password string) (models.User, error) {
+	if server.users == nil {
+		return user, errors.New("User accounts require a data directory")
+	}
+	return server.users.Save(user, password)
+}
+
+func (server *Server) DeleteUser(username string) error {
+	if server.users == nil {
+		return errors.New("User accounts require a data directory")
+	}
+	return server.users.Delete(username)
+}
+
+// EnsureAdminUser creates the given admin user, or resets its password and role if it already exists
+func (server *Server) EnsureAdminUser(username string, password string) error {
+	if server.users == nil {
+		return errors.New("User accounts require a data directory")
+	}
+	user, err := server.users.Get(username)
+	if err != nil {
+		user = models.User{Username: username}
+	}
+	user.Role = models.RoleAdmin
+	_, err = server.users.Save(user, password)
+	return err
+}
+
+// CreateSession logs in a user on the web portal, the returned session ID is meant for a cookie
+func (server *Server) CreateSession(user models.User) (string, error) {
+	return server.sessions.Create(user)
+}
+
+func (server *Server) GetSession(id string) (models.User, bool) {
+	return server.sessions.Get(id)
+}
+
+func (server *Server) DeleteSession(id string) {
+	server.sessions.Delete(id)
+}
+
+func (server *Server) CreateTicket(user models.User, sessionID string, scope string) (string, error) {
+	return server.sessions.CreateTicket(user, sessionID, scope)
+}
+
+func (server *Server) RedeemTicket(id string, scope string) (models.User, bool) {
+	return server.sessions.RedeemTicket(id, scope)
+}
+
+// Audit records an action, it is only logged if the data directory is not set
+func (server *Server) Audit(event models.AuditEvent) {
+	server.logger.WithFields(logrus.Fields{
+		"actor":      event.Actor,
+		"client":     event.ClientID,
+		"action":     event.Action,
+		"parameters": event.Parameters,
+		"outcome":    event.Outcome,
+	}).Info("Audit: " + event.Message)
+
+	if server.audit != nil {
+		if err := server.audit.Append(event); err != nil {
+			server.logger.Error(err)
+		}
+	}
+}
+
+func (server *Server) GetAuditEvents(filter AuditFilter) ([]models.AuditEvent, error) {
+	if server.audit == nil {
+		return []models.AuditEvent{}, errors.New("Audit log requires a data directory")
+	}
+	return server.audit.Query(filter)
+}
+
+func (server *Server) GetTerminalRecordings(clientID string) ([]models.TerminalRecording, error) {
+	if server.recordings == nil {
+		return []models.TerminalRecording{}, nil
+	}
+	return server.recordings.List(clientID)
+}
+
+// GetTerminalRecordingPath returns the cast file of a recording made on the given client
+func (server *Server) GetTerminalRecordingPath(clientID string, id string) (string, error) {
+	if server.recordings == nil {
+		return "", errors.New("Terminal recordings require a data directory")
+	}
+	recording, err := server.recordings.Get(id)
+	if err != nil || recording.ClientID != clientID {
+		return "", errors.New("Recording Not Found: " + id)
+	}
+	return server.recordings.Path(recording), nil
+}
+
+// CanAccessClient checks the role of a user on a client, offline clients are looked up in the registry
+func (server *Server) CanAccessClient(user models.User, clientID string, role string) bool {
+	if HasRole(user, role) {
+		return true
+	}
+	record, err := server.GetClientRecord(clientID)
+	if err != nil {
+		return false
+	}
+	return HasClientRole(user, record.Info, role)
+}
+
 func (server *Server) BulkInstallJoebot(info models.BulkInstallInfo) (string, error) {
 	sshHosts := []sshconnect.SSHHost{}
 
@@ -203,6 +737,21 @@ func (server *Server) BulkInstallJoebot(info models.BulkInstallInfo) (string, er
 		})
 	}
 
+	extraArgs := ""
+	if server.ca != nil {
+		extraArgs += " --tls --server-fingerprint " + server.ca.Fingerprint()
+	}
+	if info.Token == "" && server.RequireToken {
+		token, err := server.CreateEnrollmentToken("Bulk installation", false, time.Hour, nil)
+		if err != nil {
+			return "", err
+		}
+		info.Token = token.Token
+	}
+	if info.Token != "" {
+		extraArgs += " --token " + info.Token
+	}
+
 	var cipherList []string
 	chLimit := make(chan bool, 10)
 	chs := make([]chan sshconnect.SSHResult, len(sshHosts))
@@ -217,7 +766,7 @@ func (server *Server) BulkInstallJoebot(info models.BulkInstallInfo) (string, er
 
 		cmds := []string{
 			"chmod +x " + dstFilePath,
-			"nohup " + dstFilePath + " client -p " + strconv.Itoa(info.JoebotServerPort) + " " + info.JoebotServerIP + " &",
+			"nohup " + dstFilePath + " client -p " + strconv.Itoa(info.JoebotServerPort) + extraArgs + " " + info.JoebotServerIP + " &",
 		}
 
 		sshconnect.UploadMyself(dstFilePath, host.Username, host.Password, host.Host, host.Key, host.CmdList, host.Port, 120, cipherList, host.LinuxMode, ch)
@@ -252,3 +801,57 @@ func (server *Server) BulkInstallJoebot(info models.BulkInstallInfo) (string, er
 
 	return "", nil
 }
+
+// TunnelAddress is where the server port of a tunnel can be reached from the server itself
+func (server *Server) TunnelAddress(serverPort int) string {
+	host := server.TunnelBindAddress
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(serverPort))
+}
+
+// How often tunnels are checked for expiry
+const tunnelExpiryInterval = 10 * time.Second
+
+// expireTunnels closes the tunnels past their TTL or idle timeout until the server stops
+func (server *Server) expireTunnels() {
+	ticker := time.NewTicker(tunnelExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.ctx.Done():
+			return
+		case now := <-ticker.C:
+			<-server.clientsListLock
+			clients := append([]*Client{}, server.clients...)
+			server.clientsListLock <- true
+
+			for _, client := range clients {
+				for _, expired := range client.expiredTunnels(now) {
+					err := client.closeTunnel(expired.Tunnel)
+					event := models.AuditEvent{
+						Time:     time.Now(),
+						Actor:    "system",
+						ClientID: client.ID,
+						Action:   models.AuditTunnelExpire,
+						Parameters: map[string]string{
+							"client_port": strconv.Itoa(expired.Tunnel.ClientPort),
+							"server_port": strconv.Itoa(expired.Tunnel.ServerPort),
+							"protocol":    tunnelProtocol(expired.Tunnel.Protocol),
+							"name":        expired.Tunnel.Name,
+							"reason":      expired.Reason,
+						},
+						Outcome: models.AuditSuccess,
+					}
+					if err != nil {
+						event.Outcome = models.AuditFailure
+						event.Message = err.Error()
+					}
+					server.Audit(event)
+				}
+			}
+		}
+	}
+}
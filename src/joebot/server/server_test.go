@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/twinj/uuid"
+)
+
+// newTestServer returns a server with a registry in a temporary directory, without listening for clients
+func newTestServer(t *testing.T) *Server {
+	server := NewServer(benchLogger())
+	registry, err := NewRegistry(filepath.Join(t.TempDir(), "joebot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { registry.Close() })
+	server.registry = registry
+	server.tokens = NewTokenStore(registry)
+	return server
+}
+
+func TestAuthenticateSecret(t *testing.T) {
+	server := newTestServer(t)
+	enrolled := server.authenticate(models.Handshake{}, nil)
+	if !enrolled.Accepted || enrolled.Secret == "" {
+		t.Fatalf("enrollment: %+v", enrolled)
+	}
+	unknownID := uuid.NewV4().String()
+
+	tests := []struct {
+		name      string
+		handshake models.Handshake
+		accepted  bool
+		// Whether a new secret is issued to the client
+		newSecret bool
+	}{
+		{"enrolled client with its secret", models.Handshake{ClientID: enrolled.ClientID, Secret: enrolled.Secret}, true, false},
+		{"enrolled client with a wrong secret", models.Handshake{ClientID: enrolled.ClientID, Secret: "wrong"}, false, false},
+		{"enrolled client without secret", models.Handshake{ClientID: enrolled.ClientID}, false, false},
+		{"unknown client", models.Handshake{ClientID: unknownID}, true, true},
+	}
+	for _, tt := range tests {
+		result := server.authenticate(tt.handshake, nil)
+		if result.Accepted != tt.accepted {
+			t.Errorf("%s: accepted: %v, expected: %v (%s)", tt.name, result.Accepted, tt.accepted, result.Message)
+		}
+		if (result.Secret != "") != tt.newSecret {
+			t.Errorf("%s: new secret issued: %v, expected: %v", tt.name, result.Secret != "", tt.newSecret)
+		}
+		if result.Accepted && result.ClientID != tt.handshake.ClientID {
+			t.Errorf("%s: client ID changed to %s", tt.name, result.ClientID)
+		}
+	}
+
+	// The secret issued to the unknown client replaces any it did not present
+	if result := server.authenticate(models.Handshake{ClientID: unknownID}, nil); result.Accepted {
+		t.Error("client enrolled with a secret was accepted without it")
+	}
+}
+
+func TestAuthenticateClientID(t *testing.T) {
+	server := newTestServer(t)
+	for _, id := range []string{"", "not-a-uuid"} {
+		result := server.authenticate(models.Handshake{ClientID: id}, nil)
+		if !result.Accepted {
+			t.Errorf("client ID %q: %s", id, result.Message)
+		}
+		if _, err := uuid.Parse(result.ClientID); err != nil {
+			t.Errorf("client ID %q: new client ID %q is not a UUID", id, result.ClientID)
+		}
+	}
+}
+
+func TestAuthenticateConnectedClient(t *testing.T) {
+	// Without a registry, connected clients have no secret to check
+	server := NewServer(benchLogger())
+	connected := &Client{ID: uuid.NewV4().String()}
+	server.clients = append(server.clients, connected)
+
+	if result := server.authenticate(models.Handshake{ClientID: connected.ID}, nil); result.Accepted {
+		t.Error("client presenting the ID of a connected client was accepted")
+	}
+	if result := server.authenticate(models.Handshake{ClientID: uuid.NewV4().String()}, nil); !result.Accepted || result.Secret != "" {
+		t.Errorf("client without registry: %+v", result)
+	}
+}
+
+func certificateRequest(t *testing.T, clientID string) []byte {
+	key, err := utils.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: clientID}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// enrollWithCertificate enrolls a new client, and returns its ID along with the parsed certificate
+func enrollWithCertificate(t *testing.T, server *Server) (string, *x509.Certificate) {
+	clientID := uuid.NewV4().String()
+	result := server.authenticate(models.Handshake{ClientID: clientID, CSR: certificateRequest(t, clientID)}, nil)
+	if !result.Accepted || result.Certificate == nil {
+		t.Fatalf("enrollment: %+v", result)
+	}
+	cert, err := x509.ParseCertificate(result.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientID, cert
+}
+
+func TestAuthenticateCertificate(t *testing.T) {
+	server := newTestServer(t)
+	ca, err := LoadOrCreateCertificateAuthority(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.ca = ca
+
+	clientID, cert := enrollWithCertificate(t, server)
+	otherID, otherCert := enrollWithCertificate(t, server)
+	// Signed by the CA for the same client ID, but not the certificate recorded at enrollment
+	reissued, err := ca.IssueClientCertificate(clientID, certificateRequest(t, clientID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reissuedCert, err := x509.ParseCertificate(reissued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		handshake   models.Handshake
+		certificate *x509.Certificate
+		accepted    bool
+	}{
+		{"enrolled certificate", models.Handshake{ClientID: clientID}, cert, true},
+		{"certificate of another client", models.Handshake{ClientID: clientID}, otherCert, false},
+		{"certificate not recorded at enrollment", models.Handshake{ClientID: clientID}, reissuedCert, false},
+		{"enrolled client without certificate", models.Handshake{ClientID: clientID}, nil, false},
+		{"enrolled client re-enrolling", models.Handshake{ClientID: otherID, CSR: certificateRequest(t, otherID)}, nil, false},
+		{"new client without certificate signing request", models.Handshake{ClientID: uuid.NewV4().String()}, nil, false},
+		{"new client with an invalid certificate signing request", models.Handshake{ClientID: uuid.NewV4().String(), CSR: []byte("csr")}, nil, false},
+	}
+	for _, tt := range tests {
+		result := server.authenticate(tt.handshake, tt.certificate)
+		if result.Accepted != tt.accepted {
+			t.Errorf("%s: accepted: %v, expected: %v (%s)", tt.name, result.Accepted, tt.accepted, result.Message)
+		}
+	}
+
+	// Certificates are checked against the registry, a deleted client has to enroll again
+	if err = server.registry.Delete(clientID); err != nil {
+		t.Fatal(err)
+	}
+	if result := server.authenticate(models.Handshake{ClientID: clientID}, cert); result.Accepted {
+		t.Error("certificate of a deleted client was accepted")
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/pkg/errors"
+)
+
+// How long a web portal session stays valid after login
+const SessionTTL = 12 * time.Hour
+
+// How long a ticket can be redeemed after it is issued, which only has to cover loading the page it is given to
+const TicketTTL = 15 * time.Second
+
+type session struct {
+	user      models.User
+	expiresAt time.Time
+	// Only set on tickets, which are valid for this scope only
+	scope string
+	// Only set on tickets issued within a session, which end along with it
+	sessionID string
+}
+
+// SessionStore keeps the logged in users of the web portal in memory, so sessions end with the server
+type SessionStore struct {
+	sessions map[string]session
+	tickets  map[string]session
+	lock     sync.Mutex
+}
+
+func NewSessionStore() *SessionStore {
+	return &SessionStore{sessions: map[string]session{}, tickets: map[string]session{}}
+}
+
+func (store *SessionStore) Create(user models.User) (string, error) {
+	id, err := generateSecret()
+	if err != nil {
+		return "", errors.Wrap(err, "Failed To Generate Session ID")
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := time.Now()
+	for id, s := range store.sessions {
+		if now.After(s.expiresAt) {
+			delete(store.sessions, id)
+		}
+	}
+	store.sessions[id] = session{user: user, expiresAt: now.Add(SessionTTL)}
+	return id, nil
+}
+
+func (store *SessionStore) Get(id string) (models.User, bool) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	s, ok := store.sessions[id]
+	if !ok || time.Now().After(s.expiresAt) {
+		return models.User{}, false
+	}
+	return s.user, true
+}
+
+func (store *SessionStore) Delete(id string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	delete(store.sessions, id)
+	for ticketID, t := range store.tickets {
+		if t.sessionID == id {
+			delete(store.tickets, ticketID)
+		}
+	}
+}
+
+// CreateTicket issues a short lived, single use credential of the user, bound to the session it is issued within if any.
+// Tickets are put in URLs for pages which cannot send the session cookie, such as websockets opened by another site
+func (store *SessionStore) CreateTicket(user models.User, sessionID string, scope string) (string, error) {
+	id, err := generateSecret()
+	if err != nil {
+		return "", errors.Wrap(err, "Failed To Generate Ticket")
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := time.Now()
+	for id, t := range store.tickets {
+		if now.After(t.expiresAt) {
+			delete(store.tickets, id)
+		}
+	}
+	store.tickets[id] = session{user: user, expiresAt: now.Add(TicketTTL), scope: scope, sessionID: sessionID}
+	return id, nil
+}
+
+func (store *SessionStore) RedeemTicket(id string, scope string) (models.User, bool) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := time.Now()
+	t, ok := store.tickets[id]
+	if !ok || t.scope != scope || now.After(t.expiresAt) {
+		return models.User{}, false
+	}
+	delete(store.tickets, id)
+	if t.sessionID != "" {
+		if s, ok := store.sessions[t.sessionID]; !ok || now.After(s.expiresAt) {
+			return models.User{}, false
+		}
+	}
+	return t.user, true
+}
+
+// MapGroupsToUser grants roles to a single sign-on user according to its groups.
+// The mapping is from group to either a role, or a tag and a role in the form tag:role
+func MapGroupsToUser(username string, groups []string, mapping map[string]string) (models.User, error) {
+	user := models.User{Username: username, TagRoles: map[string]string{}}
+	for _, group := range groups {
+		grant, ok := mapping[group]
+		if !ok {
+			continue
+		}
+
+		if i := strings.LastIndex(grant, ":"); i >= 0 {
+			tag, role := grant[:i], grant[i+1:]
+			if roleLevels[role] > roleLevels[user.TagRoles[tag]] {
+				user.TagRoles[tag] = role
+			}
+		} else if roleLevels[grant] > roleLevels[user.Role] {
+			user.Role = grant
+		}
+	}
+
+	if user.Role == "" && len(user.TagRoles) == 0 {
+		return user, errors.New("No role is granted to the groups of " + username)
+	}
+	return user, nil
+}
+
+func ValidateGroupMapping(mapping map[string]string) error {
+	for group, grant := range mapping {
+		role := grant[strings.LastIndex(grant, ":")+1:]
+		if _, ok := roleLevels[role]; !ok {
+			return errors.New("Invalid role mapped to group " + group + ": " + grant)
+		}
+	}
+	return nil
+}
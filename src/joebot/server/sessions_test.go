@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+)
+
+func TestRedeemTicket(t *testing.T) {
+	store := NewSessionStore()
+	user := models.User{Username: "alice", Role: models.RoleOperator}
+	sessionID, err := store.Create(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSessionID, err := store.Create(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket := func(sessionID string) string {
+		id, err := store.CreateTicket(user, sessionID, "client")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	redeemed := ticket(sessionID)
+	if _, ok := store.RedeemTicket(redeemed, "client"); !ok {
+		t.Fatal("ticket was not redeemed")
+	}
+	wrongScope := ticket(sessionID)
+	expired := ticket(sessionID)
+	store.tickets[expired] = session{user: user, expiresAt: time.Now().Add(-time.Second), scope: "client", sessionID: sessionID}
+	loggedOut := ticket(otherSessionID)
+	store.Delete(otherSessionID)
+	endedSession := ticket(sessionID)
+	store.sessions[sessionID] = session{user: user, expiresAt: time.Now().Add(-time.Second)}
+	withoutSession := ticket("")
+
+	tests := []struct {
+		name   string
+		ticket string
+		scope  string
+		valid  bool
+	}{
+		{"already redeemed", redeemed, "client", false},
+		{"other scope", wrongScope, "other", false},
+		{"expired", expired, "client", false},
+		{"session logged out", loggedOut, "client", false},
+		{"session expired", endedSession, "client", false},
+		{"issued without session", withoutSession, "client", true},
+		{"unknown", "unknown", "client", false},
+	}
+	for _, tt := range tests {
+		redeemedUser, ok := store.RedeemTicket(tt.ticket, tt.scope)
+		if ok != tt.valid {
+			t.Errorf("%s: redeemed: %v, expected: %v", tt.name, ok, tt.valid)
+		}
+		if ok && redeemedUser.Username != user.Username {
+			t.Errorf("%s: redeemed by %s", tt.name, redeemedUser.Username)
+		}
+	}
+}
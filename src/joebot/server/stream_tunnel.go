@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/hashicorp/yamux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// StreamTunnel serves the server port of a tunnel on the yamux data plane.
+// Every accepted connection is carried by a new stream of the control session, which the client splices to its local port
+type StreamTunnel struct {
+	listener net.Listener
+	session  *yamux.Session
+	activity *TunnelActivity
+	logger   *logrus.Logger
+	conns    utils.ConnSet
+
+	// Tells the client which of its tunnels the streams belong to
+	payload []byte
+}
+
+// NewStreamTunnel listens on the address, the connections are forwarded to the tunnel of the client at the other end of the session
+func NewStreamTunnel(listenAddr string, session *yamux.Session, tunnel models.PortTunnelInfo, activity *TunnelActivity, logger *logrus.Logger) (*StreamTunnel, error) {
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed To Listen On Tunnel Port")
+	}
+
+	st := &StreamTunnel{
+		listener: ln,
+		session:  session,
+		activity: activity,
+		logger:   logger,
+		payload:  utils.StructToBytes(models.PortTunnelInfo{ServerPort: tunnel.ServerPort}),
+	}
+	go st.serve()
+	return st, nil
+}
+
+func (st *StreamTunnel) serve() {
+	for {
+		conn, err := st.listener.Accept()
+		if err != nil {
+			return
+		}
+		if st.activity != nil {
+			conn = st.activity.Wrap("", conn)
+		}
+		go st.forward(conn)
+	}
+}
+
+func (st *StreamTunnel) forward(conn net.Conn) {
+	if !st.conns.Add(conn) {
+		return
+	}
+	defer st.conns.Remove(conn)
+
+	stream, err := task.NewTask(context.Background(), task.TunnelStreamRequest, st.logger).Request(st.session, st.payload)
+	if err == nil {
+		err = task.WaitTaskCompleteSignal(10*time.Second, stream)
+	}
+	if err != nil {
+		st.logger.Info(errors.Wrap(err, "Failed To Open Tunnel Stream For "+conn.RemoteAddr().String()))
+		conn.Close()
+		if stream != nil {
+			stream.Close()
+		}
+		return
+	}
+	stream.SetReadDeadline(time.Time{})
+	utils.Splice(conn, stream)
+}
+
+// Close stops listening and closes the connections already accepted
+func (st *StreamTunnel) Close() error {
+	err := st.listener.Close()
+	st.conns.Close()
+	return err
+}
+
+type TunnelDialTask struct {
+	handleClient *Client
+	*task.Task
+}
+
+func NewTunnelDialTask(client *Client) *TunnelDialTask {
+	return &TunnelDialTask{
+		client,
+		task.NewTask(client.ctx, task.TunnelDialRequest, client.logger),
+	}
+}
+
+// Handle connects a stream opened by the client for a connection accepted on its listening end to the target address of the tunnel.
+// The target is looked up on the server, so that a client can only reach the targets of its own tunnels
+func (t *TunnelDialTask) Handle(body []byte, stream net.Conn) error {
+	if t.handleClient == nil {
+		return errors.New("handleClient param not set")
+	}
+
+	var request models.PortTunnelInfo
+	if err := utils.BytesToStruct(body, &request); err != nil {
+		return errors.Wrap(err, "Unable to decode request body into PortTunnelInfo object")
+	}
+
+	targetAddress := ""
+	for _, tunnel := range t.handleClient.portTunnels() {
+		if tunnel.ClientPort == request.ClientPort && tunnel.Listen && tunnel.DataPlane == models.DataPlaneYamux {
+			targetAddress = tunnel.TargetAddress
+		}
+	}
+	if targetAddress == "" {
+		return errors.Errorf("No tunnel listening on client port %d", request.ClientPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", targetAddress, 10*time.Second)
+	if err != nil {
+		return errors.Wrap(err, "Failed To Connect To Tunnel Target "+targetAddress)
+	}
+	if err = task.ConfirmTaskComplete(stream); err != nil {
+		conn.Close()
+		return err
+	}
+	utils.Splice(stream, conn)
+	return nil
+}
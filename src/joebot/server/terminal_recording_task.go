@@ -0,0 +1,81 @@
+package server
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/task"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Clients stop recording the output after 64 MB, the limit leaves room for the events written after that
+	maxRecordingUploadSize = 80 * 1024 * 1024
+	// maxRecordingChunkSize bounds each of the chunks a recording is streamed in
+	maxRecordingChunkSize = 1024 * 1024
+)
+
+type TerminalRecordingTask struct {
+	handleClient *Client
+	*task.Task
+}
+
+func NewTerminalRecordingTask(client *Client) *TerminalRecordingTask {
+	return &TerminalRecordingTask{
+		client,
+		task.NewTask(client.ctx, task.TerminalRecordingUpload, client.logger),
+	}
+}
+
+// recordingReader reads the cast of a recording streamed by the client in chunks, up to the empty chunk ending it
+type recordingReader struct {
+	stream net.Conn
+	chunk  []byte
+	size   int
+	ended  bool
+}
+
+func (reader *recordingReader) Read(b []byte) (int, error) {
+	for len(reader.chunk) == 0 {
+		if reader.ended {
+			return 0, io.EOF
+		}
+		chunk, err := task.ReceiveLimitedObject(reader.stream, 30*time.Second, maxRecordingChunkSize)
+		if err != nil {
+			return 0, err
+		}
+		reader.size += len(chunk)
+		if reader.size > maxRecordingUploadSize {
+			return 0, errors.New("Recording exceeds the upload limit")
+		}
+		reader.chunk, reader.ended = chunk, len(chunk) == 0
+	}
+	n := copy(b, reader.chunk)
+	reader.chunk = reader.chunk[n:]
+	return n, nil
+}
+
+func (t *TerminalRecordingTask) Handle(body []byte, stream net.Conn) error {
+	if t.handleClient == nil {
+		return errors.New("handleClient param not set")
+	}
+
+	var recording models.TerminalRecording
+	err := utils.BytesToStruct(body, &recording)
+	if err != nil {
+		return errors.Wrap(err, "Unable to decode request body into TerminalRecording object")
+	}
+	if t.handleClient.server.recordings == nil {
+		return errors.New("Terminal recordings require a data directory")
+	}
+	// The cast follows the request, it is written to disk as it arrives
+	if err = t.handleClient.server.recordings.Save(t.handleClient.ID, recording, &recordingReader{stream: stream}); err != nil {
+		return err
+	}
+
+	t.Logger.Info("Saved Terminal Recording " + recording.ID + " Of Client " + t.handleClient.ID)
+	return task.ConfirmTaskComplete(stream)
+}
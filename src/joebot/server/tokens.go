@@ -0,0 +1,100 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/pkg/errors"
+	"github.com/twinj/uuid"
+)
+
+// TokenStore keeps the enrollment tokens in the registry database
+type TokenStore struct {
+	db   storm.Node
+	lock sync.Mutex
+}
+
+func NewTokenStore(registry *Registry) *TokenStore {
+	return &TokenStore{db: registry.db.From("tokens")}
+}
+
+// Create stores a new token, the returned token is the only place where its secret value appears
+func (store *TokenStore) Create(description string, singleUse bool, ttl time.Duration, tags []string) (models.EnrollmentToken, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	secret, err := generateSecret()
+	if err != nil {
+		return models.EnrollmentToken{}, errors.Wrap(err, "Failed To Generate Token")
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	token := models.EnrollmentToken{
+		ID:          uuid.NewV4().String(),
+		TokenHash:   hashSecret(secret),
+		Description: description,
+		SingleUse:   singleUse,
+		Tags:        tags,
+		CreatedAt:   time.Now(),
+	}
+	if ttl > 0 {
+		token.ExpiresAt = token.CreatedAt.Add(ttl)
+	}
+	if err = store.db.Save(&token); err != nil {
+		return token, errors.Wrap(err, "Failed To Save Token")
+	}
+
+	token.Token = secret
+	return token, nil
+}
+
+func (store *TokenStore) List() ([]models.EnrollmentToken, error) {
+	tokens := []models.EnrollmentToken{}
+	err := store.db.All(&tokens)
+	if err == storm.ErrNotFound {
+		return tokens, nil
+	}
+	return tokens, err
+}
+
+func (store *TokenStore) Revoke(id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var token models.EnrollmentToken
+	if err := store.db.One("ID", id, &token); err != nil {
+		return errors.New("Token Not Found: " + id)
+	}
+	token.Revoked = true
+	return store.db.Save(&token)
+}
+
+// Redeem validates a token presented by a client and counts its usage
+func (store *TokenStore) Redeem(secret string) (models.EnrollmentToken, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var token models.EnrollmentToken
+	if secret == "" {
+		return token, errors.New("Enrollment token is required")
+	}
+	if err := store.db.One("TokenHash", hashSecret(secret), &token); err != nil {
+		return token, errors.New("Invalid enrollment token")
+	}
+	if token.Revoked {
+		return token, errors.New("Enrollment token has been revoked")
+	}
+	if !token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt) {
+		return token, errors.New("Enrollment token has expired")
+	}
+	if token.SingleUse && token.Uses > 0 {
+		return token, errors.New("Enrollment token has already been used")
+	}
+
+	token.Uses++
+	return token, store.db.Save(&token)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/twinj/uuid"
+)
+
+func TestTokenStoreRedeem(t *testing.T) {
+	store := newTestServer(t).tokens
+	create := func(singleUse bool, ttl time.Duration) models.EnrollmentToken {
+		token, err := store.Create("test", singleUse, ttl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	reusable := create(false, 0)
+	singleUse := create(true, 0)
+	used := create(true, 0)
+	if _, err := store.Redeem(used.Token); err != nil {
+		t.Fatal(err)
+	}
+	unexpired := create(false, time.Hour)
+	expired := create(false, time.Nanosecond)
+	revoked := create(false, 0)
+	if err := store.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		valid  bool
+	}{
+		{"reusable", reusable.Token, true},
+		{"reusable again", reusable.Token, true},
+		{"single use", singleUse.Token, true},
+		{"single use already used", used.Token, false},
+		{"single use used by the previous redeem", singleUse.Token, false},
+		{"not expired", unexpired.Token, true},
+		{"expired", expired.Token, false},
+		{"revoked", revoked.Token, false},
+		{"unknown", "unknown", false},
+		{"ID instead of secret", reusable.ID, false},
+		{"missing", "", false},
+	}
+	for _, tt := range tests {
+		token, err := store.Redeem(tt.secret)
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: token was redeemed", tt.name)
+		}
+		if tt.valid && token.Token != "" {
+			t.Errorf("%s: redeemed token carries its secret", tt.name)
+		}
+	}
+
+	tokens, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if token.ID == reusable.ID && token.Uses != 2 {
+			t.Errorf("reusable token uses: %d, expected: 2", token.Uses)
+		}
+	}
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	server := newTestServer(t)
+	token, err := server.tokens.Create("test", false, 0, []string{"lab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := server.tokens.Create("test", false, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.tokens.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		requireToken bool
+		token        string
+		accepted     bool
+	}{
+		{"token required, no token", true, "", false},
+		{"token required, invalid token", true, "invalid", false},
+		{"token required, revoked token", true, revoked.Token, false},
+		{"token required, valid token", true, token.Token, true},
+		{"token optional, no token", false, "", true},
+		{"token optional, invalid token", false, "invalid", false},
+		{"token optional, valid token", false, token.Token, true},
+	}
+	for _, tt := range tests {
+		server.RequireToken = tt.requireToken
+		result := server.authenticate(models.Handshake{ClientID: uuid.NewV4().String(), Token: tt.token}, nil)
+		if result.Accepted != tt.accepted {
+			t.Errorf("%s: accepted: %v, expected: %v (%s)", tt.name, result.Accepted, tt.accepted, result.Message)
+		}
+		if !result.Accepted || tt.token == "" {
+			continue
+		}
+		record, err := server.registry.Get(result.ClientID)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if len(record.EnrollmentTags) != 1 || record.EnrollmentTags[0] != "lab" {
+			t.Errorf("%s: enrollment tags: %v", tt.name, record.EnrollmentTags)
+		}
+	}
+
+	// Enrolled clients reconnect with their secret, without redeeming a token again
+	server.RequireToken = false
+	enrolled := server.authenticate(models.Handshake{Token: token.Token}, nil)
+	server.RequireToken = true
+	if result := server.authenticate(models.Handshake{ClientID: enrolled.ClientID, Secret: enrolled.Secret}, nil); !result.Accepted {
+		t.Errorf("enrolled client: %s", result.Message)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TunnelActivity tracks the last time traffic went through the server port of each tunnel, and its bandwidth
+type TunnelActivity struct {
+	lastActivity map[int]time.Time
+	bandwidths   map[int]*Bandwidth
+	lock         sync.Mutex
+
+	// accounts returns the other bandwidths the connections of a server port go through, such as the one of its client
+	accounts func(serverPort int) []*Bandwidth
+}
+
+func NewTunnelActivity() *TunnelActivity {
+	return &TunnelActivity{lastActivity: map[int]time.Time{}, bandwidths: map[int]*Bandwidth{}}
+}
+
+func (activity *TunnelActivity) Touch(serverPort int) {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+
+	activity.lastActivity[serverPort] = time.Now()
+}
+
+// LastActivity returns the time of the last traffic on the server port, which is zero if there was none
+func (activity *TunnelActivity) LastActivity(serverPort int) time.Time {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+
+	return activity.lastActivity[serverPort]
+}
+
+// Traffic returns the bytes which went through the server port so far
+func (activity *TunnelActivity) Traffic(serverPort int) TunnelTraffic {
+	return activity.Bandwidth(serverPort).Traffic()
+}
+
+// Bandwidth returns the bandwidth of the server port, which is created on first use
+func (activity *TunnelActivity) Bandwidth(serverPort int) *Bandwidth {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+
+	bandwidth, ok := activity.bandwidths[serverPort]
+	if !ok {
+		bandwidth = NewBandwidth(0)
+		activity.bandwidths[serverPort] = bandwidth
+	}
+	return bandwidth
+}
+
+func (activity *TunnelActivity) Forget(serverPort int) {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+
+	delete(activity.lastActivity, serverPort)
+	delete(activity.bandwidths, serverPort)
+}
+
+// Wrap is the gost forward connection wrapper, which records the traffic of connections accepted on tunnel server ports
+func (activity *TunnelActivity) Wrap(user string, conn net.Conn) net.Conn {
+	_, portStr, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return conn
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return conn
+	}
+	activity.Touch(port)
+	bandwidths := []*Bandwidth{activity.Bandwidth(port)}
+	if activity.accounts != nil {
+		bandwidths = append(bandwidths, activity.accounts(port)...)
+	}
+	return &activityConn{Conn: conn, port: port, activity: activity, bandwidths: bandwidths}
+}
+
+// activityConn touches the activity of its tunnel at most once per second, and goes through its bandwidths
+type activityConn struct {
+	// Unix time of the last touch, first for the alignment of atomic operations
+	touchedAt int64
+
+	net.Conn
+	port       int
+	activity   *TunnelActivity
+	bandwidths []*Bandwidth
+}
+
+func (conn *activityConn) touch(n int) {
+	if n <= 0 {
+		return
+	}
+	now := time.Now().Unix()
+	if atomic.SwapInt64(&conn.touchedAt, now) != now {
+		conn.activity.Touch(conn.port)
+	}
+}
+
+// limited tells whether a bandwidth of the connection has a rate limit
+func (conn *activityConn) limited() bool {
+	for _, bandwidth := range conn.bandwidths {
+		if bandwidth.Limit() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// The chunks of a rate limited connection are small, so that its delays are short and frequent
+const limitedChunkSize = 16 * 1024
+
+func (conn *activityConn) Read(b []byte) (int, error) {
+	if len(b) > limitedChunkSize && conn.limited() {
+		b = b[:limitedChunkSize]
+	}
+	n, err := conn.Conn.Read(b)
+	conn.touch(n)
+	// Delaying the next read slows down the sender
+	for _, bandwidth := range conn.bandwidths {
+		bandwidth.received(n)
+	}
+	return n, err
+}
+
+func (conn *activityConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > limitedChunkSize && conn.limited() {
+			chunk = chunk[:limitedChunkSize]
+		}
+		for _, bandwidth := range conn.bandwidths {
+			bandwidth.sending(len(chunk))
+		}
+		n, err := conn.Conn.Write(chunk)
+		conn.touch(n)
+		for _, bandwidth := range conn.bandwidths {
+			bandwidth.sent(n)
+		}
+		written += n
+		b = b[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/ginuerzh/gost"
+	"github.com/pkg/errors"
+	"github.com/twinj/uuid"
+)
+
+type tunnelCredential struct {
+	password   string
+	serverPort int
+	// Host the server port is bound on
+	bindHost string
+	// Set for connecting to the target through the tunnel service instead of binding the server port
+	targetHost string
+	targetPort int
+}
+
+// TunnelCredentials authenticates clients on the gost tunnel services. A credential is minted for every tunnel,
+// it only allows binding the server port allocated to that tunnel, or connecting to the target of a listening
+// tunnel, and is revoked once the tunnel is closed
+type TunnelCredentials struct {
+	lock        sync.RWMutex
+	credentials map[string]tunnelCredential
+}
+
+func NewTunnelCredentials() *TunnelCredentials {
+	return &TunnelCredentials{credentials: map[string]tunnelCredential{}}
+}
+
+// Issue mints a credential which only allows binding the server port on the bind host, an IP address
+// or empty for all interfaces
+func (tc *TunnelCredentials) Issue(bindHost string, serverPort int) (string, string, error) {
+	// gost requests binding on all interfaces as 0.0.0.0, and receives the bind host in its canonical form
+	ip := net.IPv4zero
+	if bindHost != "" {
+		if ip = net.ParseIP(bindHost); ip == nil {
+			return "", "", errors.New("Tunnel Bind Address Is Not An IP Address: " + bindHost)
+		}
+	}
+	return tc.issue(tunnelCredential{bindHost: ip.String(), serverPort: serverPort})
+}
+
+// IssueForward mints a credential which only allows connecting to the target address from the server
+func (tc *TunnelCredentials) IssueForward(targetAddress string) (string, string, error) {
+	host, portStr, err := net.SplitHostPort(targetAddress)
+	if err != nil {
+		return "", "", err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", "", err
+	}
+	return tc.issue(tunnelCredential{targetHost: host, targetPort: port})
+}
+
+func (tc *TunnelCredentials) issue(credential tunnelCredential) (string, string, error) {
+	password, err := generateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	user := uuid.NewV4().String()
+
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	credential.password = password
+	tc.credentials[user] = credential
+
+	return user, password, nil
+}
+
+func (tc *TunnelCredentials) Revoke(user string) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+
+	delete(tc.credentials, user)
+}
+
+// Authenticate implements gost.Authenticator
+func (tc *TunnelCredentials) Authenticate(user, password string) bool {
+	tc.lock.RLock()
+	defer tc.lock.RUnlock()
+
+	credential, ok := tc.credentials[user]
+	return ok && subtle.ConstantTimeCompare([]byte(credential.password), []byte(password)) == 1
+}
+
+// Permissions returns the gost whitelist of a user, which only allows the remote forward on its server port,
+// or the direct forward to its target
+func (tc *TunnelCredentials) Permissions(user string) (*gost.Permissions, *gost.Permissions) {
+	tc.lock.RLock()
+	defer tc.lock.RUnlock()
+
+	credential, ok := tc.credentials[user]
+	if !ok {
+		return &gost.Permissions{}, nil
+	}
+	if credential.targetHost != "" {
+		return &gost.Permissions{
+			gost.Permission{
+				Actions: gost.StringSet{"tcp"},
+				Hosts:   gost.StringSet{credential.targetHost},
+				Ports:   gost.PortSet{gost.PortRange{Min: credential.targetPort, Max: credential.targetPort}},
+			},
+		}, nil
+	}
+	return &gost.Permissions{
+		gost.Permission{
+			Actions: gost.StringSet{"rtcp"},
+			Hosts:   gost.StringSet{credential.bindHost},
+			Ports:   gost.PortSet{gost.PortRange{Min: credential.serverPort, Max: credential.serverPort}},
+		},
+	}, nil
+}
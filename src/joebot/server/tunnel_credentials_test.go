@@ -0,0 +1,158 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ginuerzh/gost"
+	"github.com/harmonicinc-com/joebot/models"
+)
+
+func canTunnel(tc *TunnelCredentials, user, action, host string, port int) bool {
+	whitelist, blacklist := tc.Permissions(user)
+	return gost.Can(action, net.JoinHostPort(host, strconv.Itoa(port)), whitelist, blacklist)
+}
+
+func TestTunnelCredentialsIssue(t *testing.T) {
+	tests := []struct {
+		bindHost string
+		// Host gost receives the bind request on, empty if the bind host is rejected
+		host string
+	}{
+		{"", "0.0.0.0"},
+		{"0.0.0.0", "0.0.0.0"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"::", "::"},
+		{"0:0:0:0:0:0:0:1", "::1"},
+		{"localhost", ""},
+		{"example.com", ""},
+		{"127.0.0.1:20000", ""},
+	}
+	for _, tt := range tests {
+		tc := NewTunnelCredentials()
+		user, password, err := tc.Issue(tt.bindHost, 20000)
+		if tt.host == "" {
+			if err == nil {
+				t.Errorf("bind host %q was accepted", tt.bindHost)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("bind host %q: %v", tt.bindHost, err)
+			continue
+		}
+		if !tc.Authenticate(user, password) {
+			t.Errorf("bind host %q: credential failed to authenticate", tt.bindHost)
+		}
+		if !canTunnel(tc, user, "rtcp", tt.host, 20000) {
+			t.Errorf("bind host %q: bind on %s:20000 was refused", tt.bindHost, tt.host)
+		}
+	}
+}
+
+func TestTunnelCredentialsPermissions(t *testing.T) {
+	tc := NewTunnelCredentials()
+	bindUser, bindPassword, err := tc.Issue("127.0.0.1", 20000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwardUser, forwardPassword, err := tc.IssueForward("10.0.0.1:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tc.Authenticate(bindUser, forwardPassword) || tc.Authenticate(forwardUser, bindPassword) {
+		t.Error("credential authenticated with the password of another user")
+	}
+	if tc.Authenticate("unknown", bindPassword) || tc.Authenticate(bindUser, "") {
+		t.Error("unknown credential authenticated")
+	}
+
+	tests := []struct {
+		user    string
+		action  string
+		host    string
+		port    int
+		allowed bool
+	}{
+		{bindUser, "rtcp", "127.0.0.1", 20000, true},
+		{bindUser, "rtcp", "0.0.0.0", 20000, false},
+		{bindUser, "rtcp", "127.0.0.1", 20001, false},
+		{bindUser, "tcp", "127.0.0.1", 20000, false},
+		{forwardUser, "tcp", "10.0.0.1", 22, true},
+		{forwardUser, "tcp", "10.0.0.2", 22, false},
+		{forwardUser, "tcp", "10.0.0.1", 23, false},
+		{forwardUser, "rtcp", "10.0.0.1", 22, false},
+		{"unknown", "rtcp", "127.0.0.1", 20000, false},
+		{"unknown", "tcp", "10.0.0.1", 22, false},
+	}
+	for _, tt := range tests {
+		if allowed := canTunnel(tc, tt.user, tt.action, tt.host, tt.port); allowed != tt.allowed {
+			t.Errorf("%s on %s:%d allowed: %v, expected: %v", tt.action, tt.host, tt.port, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestIssueForwardInvalidTarget(t *testing.T) {
+	for _, target := range []string{"", "10.0.0.1", "10.0.0.1:ssh", "::1:22"} {
+		if _, _, err := NewTunnelCredentials().IssueForward(target); err == nil {
+			t.Errorf("target %q was accepted", target)
+		}
+	}
+}
+
+func TestTunnelCredentialsRevoke(t *testing.T) {
+	tc := NewTunnelCredentials()
+	user, password, err := tc.Issue("", 20000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, otherPassword, err := tc.Issue("", 20001)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc.Revoke(user)
+	if tc.Authenticate(user, password) {
+		t.Error("revoked credential authenticated")
+	}
+	if canTunnel(tc, user, "rtcp", "0.0.0.0", 20000) {
+		t.Error("revoked credential is allowed to bind its server port")
+	}
+	if !tc.Authenticate(other, otherPassword) || !canTunnel(tc, other, "rtcp", "0.0.0.0", 20001) {
+		t.Error("revoking a credential affected another one")
+	}
+}
+
+func TestExpiredTunnelCredentialRevoked(t *testing.T) {
+	server := NewServer(benchLogger())
+	client := &Client{ID: "client", logger: server.logger, server: server}
+
+	user, password, err := server.tunnelCredentials.Issue("", 20000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(-time.Second)
+	client.Info.PortTunnels = []models.PortTunnelInfo{{
+		ServerPort: 20000,
+		ClientPort: 22,
+		GostUser:   user,
+		CreatedAt:  time.Now().Add(-time.Minute),
+		ExpiresAt:  &expiresAt,
+	}}
+
+	expired := client.expiredTunnels(time.Now())
+	if len(expired) != 1 || expired[0].Reason != "ttl" {
+		t.Fatalf("expired tunnels: %v", expired)
+	}
+	// Closing the expired tunnel releases it once the client has closed it
+	client.releaseTunnel(expired[0].Tunnel)
+	if server.tunnelCredentials.Authenticate(user, password) {
+		t.Error("credential of the expired tunnel authenticated")
+	}
+	if canTunnel(server.tunnelCredentials, user, "rtcp", "0.0.0.0", 20000) {
+		t.Error("credential of the expired tunnel is allowed to bind its server port")
+	}
+}
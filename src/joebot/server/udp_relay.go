@@ -0,0 +1,158 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Streams of UDP peers which stayed silent for this long are closed
+const udpPeerTimeout = 2 * time.Minute
+
+// UDPRelay receives the datagrams of a UDP tunnel on its server port, and forwards them to the client through a TCP tunnel.
+// Every UDP peer gets its own stream, so that replies reach the right peer
+type UDPRelay struct {
+	conn       *net.UDPConn
+	streamAddr string
+	onTraffic  func()
+	logger     *logrus.Logger
+
+	peers map[string]*udpPeer
+	lock  sync.Mutex
+}
+
+type udpPeer struct {
+	stream   net.Conn
+	lastSeen time.Time
+}
+
+// NewUDPRelay listens on the UDP address, streams are opened to the TCP tunnel at streamAddr
+func NewUDPRelay(listenAddr string, streamAddr string, onTraffic func(), logger *logrus.Logger) (*UDPRelay, error) {
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed To Listen On UDP Tunnel Port")
+	}
+
+	relay := &UDPRelay{
+		conn:       conn,
+		streamAddr: streamAddr,
+		onTraffic:  onTraffic,
+		logger:     logger,
+		peers:      map[string]*udpPeer{},
+	}
+	go relay.serve()
+	go relay.expirePeers()
+	return relay, nil
+}
+
+func (relay *UDPRelay) serve() {
+	buf := make([]byte, utils.MaxDatagramSize)
+	for {
+		n, peerAddr, err := relay.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		relay.onTraffic()
+
+		stream, err := relay.peerStream(peerAddr)
+		if err != nil {
+			relay.logger.Info(errors.Wrap(err, "Failed To Open UDP Tunnel Stream For "+peerAddr.String()))
+			continue
+		}
+		if err = utils.WriteDatagram(stream, buf[:n]); err != nil {
+			relay.closePeer(peerAddr.String(), stream)
+		}
+	}
+}
+
+// peerStream returns the stream of the peer, which is opened on its first datagram
+func (relay *UDPRelay) peerStream(peerAddr *net.UDPAddr) (net.Conn, error) {
+	relay.lock.Lock()
+	defer relay.lock.Unlock()
+
+	if relay.peers == nil {
+		return nil, errors.New("UDP Relay Is Closed")
+	}
+	if peer, ok := relay.peers[peerAddr.String()]; ok {
+		peer.lastSeen = time.Now()
+		return peer.stream, nil
+	}
+
+	stream, err := net.DialTimeout("tcp", relay.streamAddr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	peer := &udpPeer{stream: stream, lastSeen: time.Now()}
+	relay.peers[peerAddr.String()] = peer
+
+	go func() {
+		defer relay.closePeer(peerAddr.String(), stream)
+		buf := make([]byte, utils.MaxDatagramSize)
+		for {
+			n, err := utils.ReadDatagram(stream, buf)
+			if err != nil {
+				return
+			}
+			relay.onTraffic()
+			relay.lock.Lock()
+			peer.lastSeen = time.Now()
+			relay.lock.Unlock()
+			if _, err = relay.conn.WriteToUDP(buf[:n], peerAddr); err != nil {
+				return
+			}
+		}
+	}()
+	return stream, nil
+}
+
+// closePeer closes the stream of a peer, unless it has been replaced by a newer one
+func (relay *UDPRelay) closePeer(key string, stream net.Conn) {
+	relay.lock.Lock()
+	defer relay.lock.Unlock()
+
+	stream.Close()
+	if peer, ok := relay.peers[key]; ok && peer.stream == stream {
+		delete(relay.peers, key)
+	}
+}
+
+func (relay *UDPRelay) expirePeers() {
+	ticker := time.NewTicker(udpPeerTimeout / 2)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		relay.lock.Lock()
+		if relay.peers == nil {
+			relay.lock.Unlock()
+			return
+		}
+		for key, peer := range relay.peers {
+			if now.Sub(peer.lastSeen) > udpPeerTimeout {
+				peer.stream.Close()
+				delete(relay.peers, key)
+			}
+		}
+		relay.lock.Unlock()
+	}
+}
+
+// Close stops listening and closes the streams of all peers
+func (relay *UDPRelay) Close() error {
+	err := relay.conn.Close()
+
+	relay.lock.Lock()
+	defer relay.lock.Unlock()
+	for _, peer := range relay.peers {
+		peer.stream.Close()
+	}
+	relay.peers = nil
+	return err
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var roleLevels = map[string]int{
+	models.RoleViewer:   1,
+	models.RoleOperator: 2,
+	models.RoleAdmin:    3,
+}
+
+// IsValidRole accepts the known roles, and the empty role which grants nothing
+func IsValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok || role == ""
+}
+
+// HasRole checks the role of the user regardless of the client tags
+func HasRole(user models.User, role string) bool {
+	return roleLevels[user.Role] >= roleLevels[role]
+}
+
+// HasClientRole checks the role of the user on a client, either granted globally or by one of the client tags
+func HasClientRole(user models.User, info models.ClientInfo, role string) bool {
+	if HasRole(user, role) {
+		return true
+	}
+	for tag, tagRole := range user.TagRoles {
+		if utils.Contains(info.Tags, tag) && roleLevels[tagRole] >= roleLevels[role] {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// Verified credentials are cached for a while, as the web portal authenticates every request
+const verifiedCredentialTTL = time.Minute
+
+// UserStore keeps the web portal users in the registry database
+type UserStore struct {
+	db   storm.Node
+	lock sync.Mutex
+
+	verified     map[string]time.Time
+	verifiedLock sync.Mutex
+}
+
+func NewUserStore(registry *Registry) *UserStore {
+	return &UserStore{db: registry.db.From("users"), verified: map[string]time.Time{}}
+}
+
+func (store *UserStore) Get(username string) (models.User, error) {
+	var user models.User
+	if err := store.db.One("Username", username, &user); err != nil {
+		return user, errors.New("User Not Found: " + username)
+	}
+	return user, nil
+}
+
+func (store *UserStore) List() ([]models.User, error) {
+	users := []models.User{}
+	err := store.db.All(&users)
+	if err == storm.ErrNotFound {
+		return users, nil
+	}
+	return users, err
+}
+
+func (store *UserStore) Count() (int, error) {
+	return store.db.Count(&models.User{})
+}
+
+// Save creates or updates a user, the password is only changed if not empty
+func (store *UserStore) Save(user models.User, password string) (models.User, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if user.Username == "" {
+		return user, errors.New("Username is required")
+	}
+	if !IsValidRole(user.Role) {
+		return user, errors.New("Invalid role: " + user.Role)
+	}
+	for tag, role := range user.TagRoles {
+		if !IsValidRole(role) || role == "" {
+			return user, errors.New("Invalid role for tag " + tag + ": " + role)
+		}
+	}
+
+	existing, err := store.Get(user.Username)
+	if err == nil {
+		user.CreatedAt = existing.CreatedAt
+		user.PasswordHash = existing.PasswordHash
+		if existing.Role == models.RoleAdmin && user.Role != models.RoleAdmin {
+			if err = store.checkRemainingAdmin(user.Username); err != nil {
+				return user, err
+			}
+		}
+	} else {
+		if password == "" {
+			return user, errors.New("Password is required")
+		}
+		user.CreatedAt = time.Now()
+	}
+	if password != "" {
+		if user.PasswordHash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost); err != nil {
+			return user, errors.Wrap(err, "Failed To Hash Password")
+		}
+	}
+	if user.TagRoles == nil {
+		user.TagRoles = map[string]string{}
+	}
+
+	err = store.db.Save(&user)
+	store.clearVerified()
+	return user, errors.Wrap(err, "Failed To Save User")
+}
+
+func (store *UserStore) Delete(username string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	user, err := store.Get(username)
+	if err != nil {
+		return err
+	}
+	if user.Role == models.RoleAdmin {
+		if err = store.checkRemainingAdmin(username); err != nil {
+			return err
+		}
+	}
+	err = store.db.DeleteStruct(&user)
+	store.clearVerified()
+	return err
+}
+
+// checkRemainingAdmin prevents locking everyone out of the user management
+func (store *UserStore) checkRemainingAdmin(excludedUsername string) error {
+	users, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.Username != excludedUsername && user.Role == models.RoleAdmin {
+			return nil
+		}
+	}
+	return errors.New("At least one admin user is required")
+}
+
+func (store *UserStore) clearVerified() {
+	store.verifiedLock.Lock()
+	defer store.verifiedLock.Unlock()
+	store.verified = map[string]time.Time{}
+}
+
+// Authenticate verifies the password of a user
+func (store *UserStore) Authenticate(username string, password string) (models.User, error) {
+	credential := hashSecret(username + ":" + password)
+	store.verifiedLock.Lock()
+	verifiedAt, ok := store.verified[credential]
+	store.verifiedLock.Unlock()
+
+	user, err := store.Get(username)
+	if err == nil && ok && time.Since(verifiedAt) < verifiedCredentialTTL {
+		return user, nil
+	}
+	if err != nil {
+		// Compare anyway so that unknown users take as long as wrong passwords
+		dummyPasswordHashOnce.Do(func() {
+			dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("joebot"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return user, errors.New("Invalid username or password")
+	}
+	if err = bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
+		return user, errors.New("Invalid username or password")
+	}
+
+	store.verifiedLock.Lock()
+	store.verified[credential] = time.Now()
+	store.verifiedLock.Unlock()
+	return user, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/twinj/uuid"
+)
+
+func TestHasClientRole(t *testing.T) {
+	untagged := models.ClientInfo{Tags: []string{}}
+	teamA := models.ClientInfo{Tags: []string{"team-a"}}
+	shared := models.ClientInfo{Tags: []string{"team-a", "team-b"}}
+
+	viewer := models.User{Username: "viewer", Role: models.RoleViewer}
+	operator := models.User{Username: "operator", Role: models.RoleOperator}
+	admin := models.User{Username: "admin", Role: models.RoleAdmin}
+	noRole := models.User{Username: "none"}
+	viewerOperatorOnTeamA := models.User{Username: "a", Role: models.RoleViewer, TagRoles: map[string]string{"team-a": models.RoleOperator}}
+	operatorViewerOnTeamA := models.User{Username: "b", Role: models.RoleOperator, TagRoles: map[string]string{"team-a": models.RoleViewer}}
+	adminOnTeamA := models.User{Username: "c", TagRoles: map[string]string{"team-a": models.RoleAdmin}}
+	viewerOnTeamsAB := models.User{Username: "d", TagRoles: map[string]string{"team-a": models.RoleViewer, "team-b": models.RoleOperator}}
+
+	tests := []struct {
+		name    string
+		user    models.User
+		info    models.ClientInfo
+		role    string
+		allowed bool
+	}{
+		{"viewer views", viewer, untagged, models.RoleViewer, true},
+		{"viewer operates", viewer, untagged, models.RoleOperator, false},
+		{"operator operates", operator, untagged, models.RoleOperator, true},
+		{"operator administers", operator, untagged, models.RoleAdmin, false},
+		{"admin administers", admin, untagged, models.RoleAdmin, true},
+		{"no role views", noRole, teamA, models.RoleViewer, false},
+		{"operator by tag operates tagged client", viewerOperatorOnTeamA, teamA, models.RoleOperator, true},
+		{"operator by tag operates untagged client", viewerOperatorOnTeamA, untagged, models.RoleOperator, false},
+		{"operator by tag views untagged client", viewerOperatorOnTeamA, untagged, models.RoleViewer, true},
+		{"operator by tag administers tagged client", viewerOperatorOnTeamA, teamA, models.RoleAdmin, false},
+		{"lower tag role keeps the global role", operatorViewerOnTeamA, teamA, models.RoleOperator, true},
+		{"admin by tag administers tagged client", adminOnTeamA, teamA, models.RoleAdmin, true},
+		{"admin by tag views untagged client", adminOnTeamA, untagged, models.RoleViewer, false},
+		{"highest role of several tags", viewerOnTeamsAB, shared, models.RoleOperator, true},
+		{"role of the matching tag only", viewerOnTeamsAB, teamA, models.RoleOperator, false},
+	}
+	for _, tt := range tests {
+		if allowed := HasClientRole(tt.user, tt.info, tt.role); allowed != tt.allowed {
+			t.Errorf("%s: allowed: %v, expected: %v", tt.name, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestCanAccessClient(t *testing.T) {
+	server := newTestServer(t)
+	connected := NewClient(uuid.NewV4().String(), server, nil, server.logger)
+	connected.Info.Tags = []string{"team-a"}
+	server.clients = append(server.clients, connected)
+	if err := server.registry.SaveInfo(connected.Info); err != nil {
+		t.Fatal(err)
+	}
+	offline := models.ClientInfo{ID: uuid.NewV4().String(), Tags: []string{"team-a"}}
+	if err := server.registry.SaveInfo(offline); err != nil {
+		t.Fatal(err)
+	}
+	// The tags reported by a connected client take precedence over the registry
+	retagged := NewClient(uuid.NewV4().String(), server, nil, server.logger)
+	retagged.Info.Tags = []string{"team-b"}
+	server.clients = append(server.clients, retagged)
+	if err := server.registry.SaveInfo(models.ClientInfo{ID: retagged.ID, Tags: []string{"team-a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	operatorOnTeamA := models.User{Username: "a", Role: models.RoleViewer, TagRoles: map[string]string{"team-a": models.RoleOperator}}
+	admin := models.User{Username: "admin", Role: models.RoleAdmin}
+
+	tests := []struct {
+		name     string
+		user     models.User
+		clientID string
+		allowed  bool
+	}{
+		{"connected client", operatorOnTeamA, connected.ID, true},
+		{"offline client in the registry", operatorOnTeamA, offline.ID, true},
+		{"connected client which lost the tag", operatorOnTeamA, retagged.ID, false},
+		{"unknown client", operatorOnTeamA, uuid.NewV4().String(), false},
+		{"unknown client by global role", admin, uuid.NewV4().String(), true},
+	}
+	for _, tt := range tests {
+		if allowed := server.CanAccessClient(tt.user, tt.clientID, models.RoleOperator); allowed != tt.allowed {
+			t.Errorf("%s: allowed: %v, expected: %v", tt.name, allowed, tt.allowed)
+		}
+	}
+}
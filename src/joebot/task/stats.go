@@ -0,0 +1,141 @@
+package task
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Directions of the tasks in the stats, requested by this side of the session or handled for the other side
+const (
+	Requested = "requested"
+	Handled   = "handled"
+)
+
+// DurationBuckets are the upper bounds in seconds of the buckets of the task durations
+var DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// TaskStat counts the tasks of a type in a direction, along with the distribution of their durations.
+// The duration of a task lasts until its first response, or until the handler returns when it sends none,
+// so that the tunnels and the commands streaming for their whole session are not measured by their length
+type TaskStat struct {
+	Type      TaskType
+	Direction string
+	Count     uint64
+	Failures  uint64
+	// Observed counts the durations, Buckets counts them per bucket of DurationBuckets, followed by the ones above
+	Observed    uint64
+	DurationSum float64
+	Buckets     []uint64
+}
+
+type statKey struct {
+	taskType  TaskType
+	direction string
+}
+
+var stats = struct {
+	tasks map[statKey]*TaskStat
+	lock  sync.Mutex
+}{tasks: map[statKey]*TaskStat{}}
+
+func updateStat(taskType TaskType, direction string, update func(stat *TaskStat)) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	key := statKey{taskType, direction}
+	stat, ok := stats.tasks[key]
+	if !ok {
+		stat = &TaskStat{Type: taskType, Direction: direction, Buckets: make([]uint64, len(DurationBuckets)+1)}
+		stats.tasks[key] = stat
+	}
+	update(stat)
+}
+
+func observe(stat *TaskStat, duration time.Duration) {
+	seconds := duration.Seconds()
+	i := sort.SearchFloat64s(DurationBuckets, seconds)
+	stat.Buckets[i]++
+	stat.Observed++
+	stat.DurationSum += seconds
+}
+
+// Stats returns a copy of the task stats, ordered by type and direction
+func Stats() []TaskStat {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	result := make([]TaskStat, 0, len(stats.tasks))
+	for _, stat := range stats.tasks {
+		copied := *stat
+		copied.Buckets = append([]uint64{}, stat.Buckets...)
+		result = append(result, copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Type != result[j].Type {
+			return result[i].Type < result[j].Type
+		}
+		return result[i].Direction < result[j].Direction
+	})
+	return result
+}
+
+// requestStream records the duration of a requested task once its first response is read
+type requestStream struct {
+	net.Conn
+	taskType TaskType
+	start    time.Time
+	observed int32
+}
+
+func (stream *requestStream) Read(b []byte) (int, error) {
+	n, err := stream.Conn.Read(b)
+	if atomic.CompareAndSwapInt32(&stream.observed, 0, 1) {
+		duration := time.Since(stream.start)
+		updateStat(stream.taskType, Requested, func(stat *TaskStat) {
+			if err != nil {
+				stat.Failures++
+				return
+			}
+			observe(stat, duration)
+		})
+	}
+	return n, err
+}
+
+// HandledStream records a task handled for the other side of the session, and its duration once its first response is written
+type HandledStream struct {
+	net.Conn
+	taskType TaskType
+	start    time.Time
+	observed int32
+}
+
+func NewHandledStream(taskType TaskType, stream net.Conn) *HandledStream {
+	return &HandledStream{Conn: stream, taskType: taskType, start: time.Now()}
+}
+
+func (stream *HandledStream) Write(b []byte) (int, error) {
+	stream.observe()
+	return stream.Conn.Write(b)
+}
+
+func (stream *HandledStream) observe() {
+	if atomic.CompareAndSwapInt32(&stream.observed, 0, 1) {
+		duration := time.Since(stream.start)
+		updateStat(stream.taskType, Handled, func(stat *TaskStat) { observe(stat, duration) })
+	}
+}
+
+// Done counts the task once the handler returns
+func (stream *HandledStream) Done(err error) {
+	stream.observe()
+	updateStat(stream.taskType, Handled, func(stat *TaskStat) {
+		stat.Count++
+		if err != nil {
+			stat.Failures++
+		}
+	})
+}
@@ -3,6 +3,7 @@ package task
 import (
 	"context"
 	"encoding/binary"
+	"io"
 	"net"
 	"strconv"
 	"time"
@@ -26,8 +27,38 @@ const (
 	NovncRequest
 	GottyWebTerminalRequest
 	FilebrowserRequest
+	TerminalRecordingUpload
+	ClosePortTunnelRequest
+	GatewayRequest
+	TunnelStreamRequest
+	TunnelDialRequest
+	HostMetricsReport
+	ExecRequest
 )
 
+var taskTypeNames = map[TaskType]string{
+	ClientInfoUpdateRequest: "client_info_update",
+	PortTunnelRequest:       "port_tunnel",
+	SSHTunnelRequest:        "ssh_tunnel",
+	NovncRequest:            "novnc",
+	GottyWebTerminalRequest: "gotty_web_terminal",
+	FilebrowserRequest:      "filebrowser",
+	TerminalRecordingUpload: "terminal_recording_upload",
+	ClosePortTunnelRequest:  "close_port_tunnel",
+	GatewayRequest:          "gateway",
+	TunnelStreamRequest:     "tunnel_stream",
+	TunnelDialRequest:       "tunnel_dial",
+	HostMetricsReport:       "host_metrics_report",
+	ExecRequest:             "exec",
+}
+
+func (taskType TaskType) String() string {
+	if name, ok := taskTypeNames[taskType]; ok {
+		return name
+	}
+	return "unknown_" + strconv.Itoa(int(taskType))
+}
+
 type HandlerFunc func([]byte, net.Conn) error
 
 type Tasker interface {
@@ -65,6 +96,17 @@ func (task *Task) SetRequestParam(param interface{}) Tasker {
 }
 
 func (task *Task) Request(session *yamux.Session, payload []byte) (net.Conn, error) {
+	start := time.Now()
+	updateStat(task.Type, Requested, func(stat *TaskStat) { stat.Count++ })
+	stream, err := task.request(session, payload)
+	if err != nil {
+		updateStat(task.Type, Requested, func(stat *TaskStat) { stat.Failures++ })
+		return nil, err
+	}
+	return &requestStream{Conn: stream, taskType: task.Type, start: start}, nil
+}
+
+func (task *Task) request(session *yamux.Session, payload []byte) (net.Conn, error) {
 	stream, err := session.Open()
 	if err != nil {
 		return nil, errors.Wrap(err, "Session Open Failed...")
@@ -121,18 +163,30 @@ func WaitTaskCompleteSignal(timeout time.Duration, stream net.Conn) error {
 }
 
 func ReceiveObject(stream net.Conn, timeout time.Duration) ([]byte, error) {
+	return receiveObject(stream, timeout, 0)
+}
+
+// ReceiveLimitedObject is ReceiveObject for peers which are not trusted yet, it rejects objects longer than maxLen bytes
+func ReceiveLimitedObject(stream net.Conn, timeout time.Duration, maxLen uint64) ([]byte, error) {
+	return receiveObject(stream, timeout, maxLen)
+}
+
+func receiveObject(stream net.Conn, timeout time.Duration, maxLen uint64) ([]byte, error) {
 	buf := make([]byte, 8)
 	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
-	_, err := stream.Read(buf)
+	_, err := io.ReadFull(stream, buf)
 	if err != nil {
 		err = errors.Wrap(err, "ReceiveObject Unable to read request body length from stream")
 		return nil, err
 	}
 	reqBodyLen := binary.LittleEndian.Uint64(buf)
+	if maxLen > 0 && reqBodyLen > maxLen {
+		return nil, errors.Errorf("ReceiveObject Request body of %d bytes exceeds the limit of %d bytes", reqBodyLen, maxLen)
+	}
 
 	reqBody := make([]byte, reqBodyLen)
 	stream.SetReadDeadline(time.Now().Add(timeout))
-	_, err = stream.Read(reqBody)
+	_, err = io.ReadFull(stream, reqBody)
 	if err != nil {
 		err = errors.Wrap(err, "ReceiveObject Unable to read request body from stream")
 		return nil, err
@@ -141,6 +195,21 @@ func ReceiveObject(stream net.Conn, timeout time.Duration) ([]byte, error) {
 	return reqBody, nil
 }
 
+// ReceiveStreamedObject reads an object sent by a task streaming its results, which may come at any time before the deadline
+func ReceiveStreamedObject(stream net.Conn, deadline time.Time) ([]byte, error) {
+	buf := make([]byte, 8)
+	stream.SetReadDeadline(deadline)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return nil, errors.Wrap(err, "ReceiveStreamedObject Unable to read object length from stream")
+	}
+
+	body := make([]byte, binary.LittleEndian.Uint64(buf))
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, errors.Wrap(err, "ReceiveStreamedObject Unable to read object from stream")
+	}
+	return body, nil
+}
+
 func SendObject(payload []byte, stream net.Conn, timeout time.Duration) error {
 	bs := make([]byte, 8)
 	binary.LittleEndian.PutUint64(bs, uint64(len(payload)))
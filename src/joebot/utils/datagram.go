@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// MaxDatagramSize is the largest UDP payload carried by a datagram stream
+const MaxDatagramSize = 65535
+
+// WriteDatagram frames a UDP payload with its length, so that datagrams keep their boundaries over a TCP stream
+func WriteDatagram(w io.Writer, payload []byte) error {
+	if len(payload) > MaxDatagramSize {
+		return errors.New("Datagram Too Large")
+	}
+	frame := make([]byte, 2+len(payload))
+	binary.BigEndian.PutUint16(frame, uint16(len(payload)))
+	copy(frame[2:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadDatagram reads a payload written by WriteDatagram into buf, which must hold MaxDatagramSize bytes
+func ReadDatagram(r io.Reader, buf []byte) (int, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	size := int(binary.BigEndian.Uint16(header[:]))
+	if size > len(buf) {
+		return 0, errors.New("Datagram Buffer Too Small")
+	}
+	return io.ReadFull(r, buf[:size])
+}
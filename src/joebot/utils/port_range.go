@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// PortRange is an inclusive range of ports, the zero value stands for any free port
+type PortRange struct {
+	Low  int `json:"low"`
+	High int `json:"high"`
+}
+
+// ParsePortRange parses a range in the form LOW-HIGH, or a single port
+func ParsePortRange(s string) (PortRange, error) {
+	bounds := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	low, err := strconv.Atoi(bounds[0])
+	high := low
+	if err == nil && len(bounds) == 2 {
+		high, err = strconv.Atoi(bounds[1])
+	}
+	if err != nil || low <= 0 || high > 65535 || low > high {
+		return PortRange{}, errors.New("Invalid Port Range: " + s)
+	}
+	return PortRange{Low: low, High: high}, nil
+}
+
+func (r PortRange) IsZero() bool {
+	return r.Low == 0 && r.High == 0
+}
+
+// Contains tells if the port is in the range, any port is in the zero range
+func (r PortRange) Contains(port int) bool {
+	return r.IsZero() || (port >= r.Low && port <= r.High)
+}
+
+func (r PortRange) Size() int {
+	if r.IsZero() {
+		return 0
+	}
+	return r.High - r.Low + 1
+}
+
+func (r PortRange) String() string {
+	if r.IsZero() {
+		return "any"
+	}
+	return strconv.Itoa(r.Low) + "-" + strconv.Itoa(r.High)
+}
+
+// PortRangeExhaustedError is returned when every port of a range is reserved or taken on the host
+type PortRangeExhaustedError struct {
+	Range PortRange
+}
+
+func (e *PortRangeExhaustedError) Error() string {
+	return "Port Range Exhausted: No Free Port In " + e.Range.String()
+}
@@ -2,81 +2,271 @@ package utils
 
 import (
 	"errors"
+	"math/bits"
+	"sort"
 	"strconv"
+	"sync"
 )
 
+const maxPort = 65535
+
+// PortLease is a port leased to an owner, such as a client or a service
+type PortLease struct {
+	Port  int    `json:"port"`
+	Owner string `json:"owner"`
+}
+
+// PortsManager leases ports to owners. The allowed ports are kept as sorted ranges and the leased ports as a bitmap,
+// candidates are still probed on the host, as other processes may be listening on them
 type PortsManager struct {
-	portsInUse   map[int]bool
-	allowedPorts map[int]bool
+	// Any port is allowed if empty
+	allowed []PortRange
+	leased  [(maxPort + 1) / 64]uint64
+	owners  map[int]string
+
+	// isFree tells if the port can be bound on the host
+	isFree func(port int) bool
 
-	lock chan bool
+	lock sync.Mutex
 }
 
 func NewPortsManager() *PortsManager {
-	obj := new(PortsManager)
-	obj.portsInUse = make(map[int](bool))
-	obj.allowedPorts = make(map[int](bool))
-	obj.lock = make(chan bool, 1)
-	obj.lock <- true
+	return &PortsManager{
+		owners: map[int]string{},
+		isFree: func(port int) bool { return !IsPortOccupied(port) },
+	}
+}
+
+// AddAllowedRange allows the ports of the range, which is merged with the ranges already allowed
+func (p *PortsManager) AddAllowedRange(r PortRange) {
+	if r.IsZero() {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
 
-	return obj
+	ranges := append(p.allowed, r)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Low < ranges[j].Low })
+	merged := ranges[:1]
+	for _, next := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if next.Low <= last.High+1 {
+			if next.High > last.High {
+				last.High = next.High
+			}
+			continue
+		}
+		merged = append(merged, next)
+	}
+	p.allowed = merged
 }
 
-func (p *PortsManager) AddAllowedPort(port int) {
-	<-p.lock
-	defer func() { p.lock <- true }()
+func (p *PortsManager) isAllowed(port int) bool {
+	if len(p.allowed) == 0 {
+		return true
+	}
+	i := sort.Search(len(p.allowed), func(i int) bool { return p.allowed[i].High >= port })
+	return i < len(p.allowed) && p.allowed[i].Low <= port
+}
 
-	p.allowedPorts[port] = true
+func (p *PortsManager) isLeased(port int) bool {
+	return p.leased[port/64]&(1<<uint(port%64)) != 0
 }
 
-func (p *PortsManager) ReservePort() (int, error) {
-	<-p.lock
-	defer func() { p.lock <- true }()
+func (p *PortsManager) lease(port int, owner string) {
+	p.leased[port/64] |= 1 << uint(port%64)
+	p.owners[port] = owner
+}
 
-	getAllowedAndFreePort := func() (int, error) {
-		if len(p.allowedPorts) == 0 {
-			return 0, nil
+// reserveIn leases the lowest port of the range which is neither leased nor taken on the host, the lock must be held
+func (p *PortsManager) reserveIn(low int, high int, owner string) (int, bool) {
+	if low < 1 {
+		low = 1
+	}
+	if high > maxPort {
+		high = maxPort
+	}
+	for word := low / 64; word <= high/64; word++ {
+		free := ^p.leased[word]
+		for free != 0 {
+			port := word*64 + bits.TrailingZeros64(free)
+			free &= free - 1
+			if port < low {
+				continue
+			}
+			if port > high {
+				break
+			}
+			if p.isFree(port) {
+				p.lease(port, owner)
+				return port, true
+			}
 		}
+	}
+	return 0, false
+}
+
+// ReservePort leases the lowest available allowed port, or a random free port if any port is allowed
+func (p *PortsManager) ReservePort(owner string) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 
-		for port := range p.allowedPorts {
-			if _, isUsed := p.portsInUse[port]; !isUsed {
-				_, err := GetFreePort(port, port)
-				if err == nil {
-					return port, nil
-				}
+	if len(p.allowed) == 0 {
+		for i := 0; i < 100; i++ {
+			port, err := GetFreePort(0, 0)
+			if err != nil {
+				return 0, err
+			}
+			if !p.isLeased(port) {
+				p.lease(port, owner)
+				return port, nil
 			}
 		}
-
-		return -1, errors.New("All allowed ports are unavailable")
+		return 0, errors.New("Unable To Reserve A Free Port")
 	}
 
-	for i := 0; i < 10000; i++ {
-		allowedAndFreePort, err := getAllowedAndFreePort()
-		if err != nil {
-			return 0, err
+	for _, r := range p.allowed {
+		if port, ok := p.reserveIn(r.Low, r.High, owner); ok {
+			return port, nil
 		}
+	}
+	if len(p.allowed) == 1 {
+		return 0, &PortRangeExhaustedError{Range: p.allowed[0]}
+	}
+	return 0, errors.New("All Allowed Ports Are Unavailable")
+}
 
-		freePort, err := GetFreePort(allowedAndFreePort, allowedAndFreePort)
-		if err != nil {
-			return 0, err
+// ReservePortInRange leases the lowest allowed port of the range which is available
+func (p *PortsManager) ReservePortInRange(r PortRange, owner string) (int, error) {
+	if r.IsZero() {
+		return p.ReservePort(owner)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.allowed) == 0 {
+		if port, ok := p.reserveIn(r.Low, r.High, owner); ok {
+			return port, nil
+		}
+	}
+	for _, allowed := range p.allowed {
+		if allowed.High < r.Low || allowed.Low > r.High {
+			continue
 		}
-		if _, isUsed := p.portsInUse[freePort]; !isUsed {
-			p.portsInUse[freePort] = true
-			return freePort, nil
+		low, high := allowed.Low, allowed.High
+		if r.Low > low {
+			low = r.Low
 		}
+		if r.High < high {
+			high = r.High
+		}
+		if port, ok := p.reserveIn(low, high, owner); ok {
+			return port, nil
+		}
+	}
+	return 0, &PortRangeExhaustedError{Range: r}
+}
+
+// ReserveSpecificPort leases the given port if it is allowed, not leased and free on the host
+func (p *PortsManager) ReserveSpecificPort(port int, owner string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if port < 1 || port > maxPort || !p.isAllowed(port) {
+		return errors.New("Port Is Not Allowed: " + strconv.Itoa(port))
+	}
+	if p.isLeased(port) {
+		return errors.New("Port Already In Use: " + strconv.Itoa(port))
+	}
+	if !p.isFree(port) {
+		return errors.New("Port Is Taken On The Host: " + strconv.Itoa(port))
 	}
 
-	return 0, errors.New("Unable To Reserve A Free Port")
+	p.lease(port, owner)
+	return nil
 }
 
 func (p *PortsManager) ReleasePort(port int) error {
-	<-p.lock
-	defer func() { p.lock <- true }()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if port < 1 || port > maxPort || !p.isLeased(port) {
+		return errors.New("Unable To Release Port Because Port Not In Use: " + strconv.Itoa(port))
+	}
+	p.leased[port/64] &^= 1 << uint(port%64)
+	delete(p.owners, port)
+	return nil
+}
+
+// ReleaseOwner releases every port leased to the owner, and returns them in ascending order
+func (p *PortsManager) ReleaseOwner(owner string) []int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ports := []int{}
+	for port, o := range p.owners {
+		if o == owner {
+			p.leased[port/64] &^= 1 << uint(port%64)
+			delete(p.owners, port)
+			ports = append(ports, port)
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// Owner returns the owner of a leased port
+func (p *PortsManager) Owner(port int) (string, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	owner, ok := p.owners[port]
+	return owner, ok
+}
 
-	if _, ok := p.portsInUse[port]; ok {
-		delete(p.portsInUse, port)
-		return nil
+// Leases returns the leased ports in ascending order
+func (p *PortsManager) Leases() []PortLease {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	leases := make([]PortLease, 0, len(p.owners))
+	for port, owner := range p.owners {
+		leases = append(leases, PortLease{Port: port, Owner: owner})
 	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Port < leases[j].Port })
+	return leases
+}
 
-	return errors.New("Unable To Release Port Because Port Not In Use: " + strconv.Itoa(port))
+// InUse counts the leased ports of the range, or all of them for the zero range
+func (p *PortsManager) InUse(r PortRange) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if r.IsZero() {
+		return len(p.owners)
+	}
+	count := 0
+	for word := r.Low / 64; word <= r.High/64 && word < len(p.leased); word++ {
+		mask := ^uint64(0)
+		if word == r.Low/64 {
+			mask &= ^uint64(0) << uint(r.Low%64)
+		}
+		if word == r.High/64 {
+			mask &= ^uint64(0) >> uint(63-r.High%64)
+		}
+		count += bits.OnesCount64(p.leased[word] & mask)
+	}
+	return count
+}
+
+// Allowed counts the allowed ports, zero means any port is allowed
+func (p *PortsManager) Allowed() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	count := 0
+	for _, r := range p.allowed {
+		count += r.Size()
+	}
+	return count
 }
@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// newTestPortsManager does not probe the host, apart from the ports listed as taken
+func newTestPortsManager(taken ...int) *PortsManager {
+	p := NewPortsManager()
+	p.isFree = func(port int) bool {
+		for _, t := range taken {
+			if t == port {
+				return false
+			}
+		}
+		return true
+	}
+	return p
+}
+
+func TestReservePortInAllowedRanges(t *testing.T) {
+	p := newTestPortsManager(20001)
+	p.AddAllowedRange(PortRange{Low: 20000, High: 20002})
+	p.AddAllowedRange(PortRange{Low: 30000, High: 30000})
+
+	ports := []int{}
+	for i := 0; i < 3; i++ {
+		port, err := p.ReservePort("client")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports = append(ports, port)
+	}
+	// 20001 is taken on the host
+	if !reflect.DeepEqual(ports, []int{20000, 20002, 30000}) {
+		t.Errorf("unexpected ports: %v", ports)
+	}
+	if _, err := p.ReservePort("client"); err == nil {
+		t.Error("port was reserved out of the allowed ranges")
+	}
+}
+
+func TestReservePortWithoutAllowedRange(t *testing.T) {
+	p := NewPortsManager()
+	port, err := p.ReservePort("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner, ok := p.Owner(port); !ok || owner != "client" {
+		t.Errorf("unexpected owner of port %d: %s", port, owner)
+	}
+}
+
+func TestReservePortInRange(t *testing.T) {
+	p := newTestPortsManager()
+	r := PortRange{Low: 40000, High: 40001}
+	for _, expected := range []int{40000, 40001} {
+		port, err := p.ReservePortInRange(r, "client")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != expected {
+			t.Errorf("expected port %d, got %d", expected, port)
+		}
+	}
+
+	_, err := p.ReservePortInRange(r, "client")
+	exhausted, ok := err.(*PortRangeExhaustedError)
+	if !ok || exhausted.Range != r {
+		t.Errorf("expected range exhausted error, got %v", err)
+	}
+
+	p.ReleasePort(40000)
+	if port, err := p.ReservePortInRange(r, "client"); err != nil || port != 40000 {
+		t.Errorf("released port was not reused: %d %v", port, err)
+	}
+}
+
+func TestReservePortInRangeIntersectsAllowedRanges(t *testing.T) {
+	p := newTestPortsManager()
+	p.AddAllowedRange(PortRange{Low: 100, High: 200})
+	port, err := p.ReservePortInRange(PortRange{Low: 150, High: 300}, "client")
+	if err != nil || port != 150 {
+		t.Errorf("unexpected port: %d %v", port, err)
+	}
+	if _, err = p.ReservePortInRange(PortRange{Low: 201, High: 300}, "client"); err == nil {
+		t.Error("port was reserved out of the allowed ranges")
+	}
+}
+
+func TestReserveSpecificPort(t *testing.T) {
+	p := newTestPortsManager(50002)
+	p.AddAllowedRange(PortRange{Low: 50000, High: 50002})
+
+	if err := p.ReserveSpecificPort(50001, "a"); err != nil {
+		t.Fatal(err)
+	}
+	for name, port := range map[string]int{"leased": 50001, "not allowed": 50003, "taken on host": 50002, "invalid": 70000} {
+		if err := p.ReserveSpecificPort(port, "b"); err == nil {
+			t.Errorf("%s port %d was reserved", name, port)
+		}
+	}
+	if owner, _ := p.Owner(50001); owner != "a" {
+		t.Errorf("unexpected owner: %s", owner)
+	}
+}
+
+func TestReleasePort(t *testing.T) {
+	p := newTestPortsManager()
+	if err := p.ReleasePort(1234); err == nil {
+		t.Error("port which is not leased was released")
+	}
+	p.ReserveSpecificPort(1234, "a")
+	if err := p.ReleasePort(1234); err != nil {
+		t.Error(err)
+	}
+	if _, ok := p.Owner(1234); ok {
+		t.Error("released port still has an owner")
+	}
+}
+
+func TestReleaseOwner(t *testing.T) {
+	p := newTestPortsManager()
+	for _, port := range []int{3000, 1000, 2000} {
+		p.ReserveSpecificPort(port, "a")
+	}
+	p.ReserveSpecificPort(4000, "b")
+
+	if released := p.ReleaseOwner("a"); !reflect.DeepEqual(released, []int{1000, 2000, 3000}) {
+		t.Errorf("unexpected released ports: %v", released)
+	}
+	if leases := p.Leases(); !reflect.DeepEqual(leases, []PortLease{{Port: 4000, Owner: "b"}}) {
+		t.Errorf("unexpected leases: %v", leases)
+	}
+}
+
+func TestAddAllowedRangeMergesRanges(t *testing.T) {
+	p := newTestPortsManager()
+	p.AddAllowedRange(PortRange{Low: 10, High: 20})
+	p.AddAllowedRange(PortRange{Low: 30, High: 40})
+	p.AddAllowedRange(PortRange{Low: 21, High: 29})
+	p.AddAllowedRange(PortRange{Low: 100, High: 100})
+	p.AddAllowedRange(PortRange{Low: 15, High: 35})
+
+	expected := []PortRange{{Low: 10, High: 40}, {Low: 100, High: 100}}
+	if !reflect.DeepEqual(p.allowed, expected) {
+		t.Errorf("unexpected allowed ranges: %v", p.allowed)
+	}
+	if p.Allowed() != 32 {
+		t.Errorf("unexpected allowed port count: %d", p.Allowed())
+	}
+}
+
+func TestInUse(t *testing.T) {
+	p := newTestPortsManager()
+	for _, port := range []int{63, 64, 127, 128, 1000} {
+		p.ReserveSpecificPort(port, "a")
+	}
+	cases := map[PortRange]int{
+		{}:                      5,
+		{Low: 63, High: 63}:     1,
+		{Low: 64, High: 127}:    2,
+		{Low: 1, High: 128}:     4,
+		{Low: 129, High: 999}:   0,
+		{Low: 1, High: 65535}:   5,
+		{Low: 1000, High: 1000}: 1,
+	}
+	for r, expected := range cases {
+		if count := p.InUse(r); count != expected {
+			t.Errorf("expected %d ports in use in %s, got %d", expected, r, count)
+		}
+	}
+}
+
+func TestReservePortSkipsPortsTakenOnHost(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	taken := ln.Addr().(*net.TCPAddr).Port
+
+	p := NewPortsManager()
+	if err = p.ReserveSpecificPort(taken, "a"); err == nil {
+		t.Errorf("port %d which is taken on the host was reserved", taken)
+	}
+}
+
+func TestConcurrentReservationsAreUnique(t *testing.T) {
+	p := newTestPortsManager()
+	r := PortRange{Low: 10000, High: 10999}
+
+	var wg sync.WaitGroup
+	ports := make(chan int, r.Size())
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < r.Size()/10; j++ {
+				port, err := p.ReservePortInRange(r, "client")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ports <- port
+			}
+		}()
+	}
+	wg.Wait()
+	close(ports)
+
+	seen := map[int]bool{}
+	for port := range ports {
+		if seen[port] {
+			t.Errorf("port %d was reserved twice", port)
+		}
+		seen[port] = true
+	}
+	if p.InUse(r) != r.Size() {
+		t.Errorf("expected the range to be full, %d ports in use", p.InUse(r))
+	}
+}
+
+func BenchmarkAddAllowedRange(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		newTestPortsManager().AddAllowedRange(PortRange{Low: 1, High: 65535})
+	}
+}
+
+func BenchmarkReserveReleasePort(b *testing.B) {
+	p := newTestPortsManager()
+	p.AddAllowedRange(PortRange{Low: 20000, High: 20999})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		port, err := p.ReservePort("client")
+		if err != nil {
+			b.Fatal(err)
+		}
+		p.ReleasePort(port)
+	}
+}
+
+// BenchmarkReservePortNearlyFull reserves the last free port of a large range
+func BenchmarkReservePortNearlyFull(b *testing.B) {
+	p := newTestPortsManager()
+	r := PortRange{Low: 1024, High: 65535}
+	p.AddAllowedRange(r)
+	for i := r.Low; i < r.High; i++ {
+		p.ReserveSpecificPort(i, "client")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		port, err := p.ReservePort("client")
+		if err != nil {
+			b.Fatal(err)
+		}
+		p.ReleasePort(port)
+	}
+}
+
+func BenchmarkReleaseOwner(b *testing.B) {
+	p := newTestPortsManager()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for port := 20000; port < 21000; port++ {
+			owner := "a"
+			if port%2 == 0 {
+				owner = "b"
+			}
+			p.ReserveSpecificPort(port, owner)
+		}
+		b.StartTimer()
+		p.ReleaseOwner("a")
+		p.ReleaseOwner("b")
+	}
+}
+
+func BenchmarkInUse(b *testing.B) {
+	p := newTestPortsManager()
+	for port := 1024; port < 65535; port += 3 {
+		p.ReserveSpecificPort(port, "client")
+	}
+	r := PortRange{Low: 1024, High: 65535}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.InUse(r)
+	}
+}
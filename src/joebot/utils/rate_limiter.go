@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter delays the bytes going through it beyond its rate, it lets a second worth of bytes through at once.
+// A rate of zero means unlimited
+type RateLimiter struct {
+	// Bytes per second
+	rate float64
+	// Bytes which can go through without delay, negative when the bytes already taken are ahead of the rate
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(bytesPerSecond)
+	return l
+}
+
+// SetRate changes the rate, the bytes already taken are delayed according to the previous rate
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if bytesPerSecond < 0 {
+		bytesPerSecond = 0
+	}
+	l.rate = float64(bytesPerSecond)
+	l.tokens = l.rate
+	l.last = time.Now()
+}
+
+func (l *RateLimiter) Rate() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return int64(l.rate)
+}
+
+// reserve takes n bytes and returns how long to wait before they can go through
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait takes n bytes and sleeps until the rate lets them go through
+func (l *RateLimiter) Wait(n int) {
+	if delay := l.reserve(n); delay > 0 {
+		time.Sleep(delay)
+	}
+}
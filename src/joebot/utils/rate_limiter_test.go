@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l := NewRateLimiter(0)
+	for i := 0; i < 1000; i++ {
+		if delay := l.reserve(1 << 20); delay != 0 {
+			t.Fatalf("unlimited rate delayed %d bytes by %s", 1<<20, delay)
+		}
+	}
+}
+
+func TestRateLimiterDelaysBytesBeyondRate(t *testing.T) {
+	l := NewRateLimiter(1000)
+
+	// A second worth of bytes goes through at once
+	if delay := l.reserve(1000); delay != 0 {
+		t.Errorf("burst was delayed by %s", delay)
+	}
+	delay := l.reserve(500)
+	if delay < 490*time.Millisecond || delay > 500*time.Millisecond {
+		t.Errorf("expected a delay of 500ms, got %s", delay)
+	}
+	// The bytes already taken delay the next ones
+	delay = l.reserve(500)
+	if delay < 990*time.Millisecond || delay > time.Second {
+		t.Errorf("expected a delay of 1s, got %s", delay)
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	l := NewRateLimiter(1000)
+	l.reserve(5000)
+
+	l.SetRate(0)
+	if l.Rate() != 0 || l.reserve(5000) != 0 {
+		t.Error("rate is still limited")
+	}
+	l.SetRate(2000)
+	if l.Rate() != 2000 || l.reserve(2000) != 0 {
+		t.Error("unexpected delay after changing the rate")
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := NewRateLimiter(100 * 1024)
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		l.Wait(10 * 1024)
+	}
+	// 100KB go through at once, the other 50KB take half a second
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected 150KB to take 500ms, took %s", elapsed)
+	}
+}
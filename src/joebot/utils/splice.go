@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// Splice copies data both ways between the connections until either side is done, then closes both
+func Splice(a net.Conn, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+// ConnSet keeps track of open connections, so that they can be closed all at once
+type ConnSet struct {
+	conns  map[net.Conn]struct{}
+	closed bool
+	lock   sync.Mutex
+}
+
+// Add tracks the connection, which is closed right away if the set is already closed
+func (set *ConnSet) Add(conn net.Conn) bool {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	if set.closed {
+		conn.Close()
+		return false
+	}
+	if set.conns == nil {
+		set.conns = map[net.Conn]struct{}{}
+	}
+	set.conns[conn] = struct{}{}
+	return true
+}
+
+func (set *ConnSet) Remove(conn net.Conn) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	delete(set.conns, conn)
+}
+
+// Close closes the connections in the set, as well as the ones added afterwards
+func (set *ConnSet) Close() {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	for conn := range set.conns {
+		conn.Close()
+	}
+	set.conns = nil
+	set.closed = true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/server"
+
+	"github.com/labstack/echo"
+)
+
+// audit records the outcome of an action taken by the current user
+func audit(c echo.Context, s *server.Server, action string, clientID string, parameters map[string]string, err error) {
+	auditAs(c, s, currentUser(c).Username, action, clientID, parameters, err)
+}
+
+func auditAs(c echo.Context, s *server.Server, actor string, action string, clientID string, parameters map[string]string, err error) {
+	event := models.AuditEvent{
+		Time:       time.Now(),
+		Actor:      actor,
+		SourceIP:   c.RealIP(),
+		ClientID:   clientID,
+		Action:     action,
+		Parameters: parameters,
+		Outcome:    models.AuditSuccess,
+	}
+	if err != nil {
+		event.Outcome = models.AuditFailure
+		event.Message = err.Error()
+	}
+	s.Audit(event)
+}
+
+// writeAuditCSV exports the events with one column per field, parameters are flattened as key=value pairs
+func writeAuditCSV(w io.Writer, events []models.AuditEvent) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "time", "actor", "source_ip", "client_id", "action", "parameters", "outcome", "message"})
+	for _, event := range events {
+		parameters := []string{}
+		for key, value := range event.Parameters {
+			parameters = append(parameters, key+"="+value)
+		}
+		sort.Strings(parameters)
+
+		writer.Write([]string{
+			strconv.Itoa(event.ID),
+			event.Time.Format(time.RFC3339),
+			event.Actor,
+			event.SourceIP,
+			event.ClientID,
+			event.Action,
+			strings.Join(parameters, ";"),
+			event.Outcome,
+			event.Message,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/server"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// Used while no user is configured, in which case the web portal stays open as before
+var anonymousUser = models.User{Username: "anonymous", Role: models.RoleAdmin}
+
+// authMiddleware authenticates web portal users with their session cookie or basic auth, the user is stored in the echo context
+func authMiddleware(s *server.Server, oidcConfig *OIDCConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cookie, err := c.Cookie(sessionCookieName); err == nil {
+				if user, ok := s.GetSession(cookie.Value); ok {
+					c.Set("user", user)
+					c.Set("session", cookie.Value)
+					return next(c)
+				}
+			}
+			if oidcConfig == nil && !s.UsersEnabled() {
+				c.Set("user", anonymousUser)
+				return next(c)
+			}
+
+			username, password, ok := c.Request().BasicAuth()
+			if ok {
+				user, err := s.AuthenticateUser(username, password)
+				if err == nil {
+					c.Set("user", user)
+					return next(c)
+				}
+				auditAs(c, s, username, models.AuditLogin, "", nil, err)
+			}
+			// Browsers are sent to the single sign-on login by the web portal instead of the basic auth prompt
+			if oidcConfig == nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="Joebot"`)
+			}
+			return c.JSON(http.StatusUnauthorized, echo.Map{"message": "Unauthorized"})
+		}
+	}
+}
+
+func currentUser(c echo.Context) models.User {
+	user, _ := c.Get("user").(models.User)
+	return user
+}
+
+// requireRole only allows users having the role on every client
+func requireRole(s *server.Server, role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !server.HasRole(currentUser(c), role) {
+				auditDenied(c, s)
+				return c.JSON(http.StatusForbidden, echo.Map{"message": "Forbidden, " + role + " role is required"})
+			}
+			return next(c)
+		}
+	}
+}
+
+// requireClientRole only allows users having the role on the client given by the id parameter
+func requireClientRole(s *server.Server, role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !s.CanAccessClient(currentUser(c), c.Param("id"), role) {
+				auditDenied(c, s)
+				return c.JSON(http.StatusForbidden, echo.Map{"message": "Forbidden, " + role + " role is required on client " + c.Param("id")})
+			}
+			return next(c)
+		}
+	}
+}
+
+func auditDenied(c echo.Context, s *server.Server) {
+	audit(c, s, models.AuditAccessDenied, c.Param("id"), map[string]string{"method": c.Request().Method, "path": c.Request().URL.Path}, errors.New("Forbidden"))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/server"
+	"github.com/labstack/echo"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRequireClientRole(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	s := server.NewServer(logger)
+	addClient := func(id string, tags ...string) {
+		client := server.NewClient(id, s, nil, logger)
+		client.Info.Tags = tags
+		s.AddClient(client)
+	}
+	addClient("untagged")
+	addClient("team-a", "team-a")
+	addClient("team-ab", "team-a", "team-b")
+
+	viewer := models.User{Username: "viewer", Role: models.RoleViewer}
+	operator := models.User{Username: "operator", Role: models.RoleOperator}
+	admin := models.User{Username: "admin", Role: models.RoleAdmin}
+	viewerOperatorOnTeamA := models.User{Username: "a", Role: models.RoleViewer, TagRoles: map[string]string{"team-a": models.RoleOperator}}
+	operatorOnTeamB := models.User{Username: "b", TagRoles: map[string]string{"team-a": models.RoleViewer, "team-b": models.RoleOperator}}
+
+	tests := []struct {
+		user     models.User
+		clientID string
+		role     string
+		status   int
+	}{
+		{viewer, "untagged", models.RoleViewer, http.StatusOK},
+		{viewer, "team-a", models.RoleOperator, http.StatusForbidden},
+		{operator, "untagged", models.RoleOperator, http.StatusOK},
+		{operator, "team-a", models.RoleAdmin, http.StatusForbidden},
+		{admin, "team-a", models.RoleAdmin, http.StatusOK},
+		{admin, "unknown", models.RoleAdmin, http.StatusOK},
+		{viewerOperatorOnTeamA, "team-a", models.RoleOperator, http.StatusOK},
+		{viewerOperatorOnTeamA, "untagged", models.RoleOperator, http.StatusForbidden},
+		{viewerOperatorOnTeamA, "untagged", models.RoleViewer, http.StatusOK},
+		{viewerOperatorOnTeamA, "team-a", models.RoleAdmin, http.StatusForbidden},
+		{operatorOnTeamB, "team-ab", models.RoleOperator, http.StatusOK},
+		{operatorOnTeamB, "team-a", models.RoleOperator, http.StatusForbidden},
+		{operatorOnTeamB, "team-a", models.RoleViewer, http.StatusOK},
+		{operatorOnTeamB, "unknown", models.RoleViewer, http.StatusForbidden},
+		{models.User{}, "untagged", models.RoleViewer, http.StatusForbidden},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/client/"+tt.clientID, nil), rec)
+		c.SetParamNames("id")
+		c.SetParamValues(tt.clientID)
+		c.Set("user", tt.user)
+
+		handler := requireClientRole(s, tt.role)(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		if err := handler(c); err != nil {
+			t.Error(err)
+		}
+		if rec.Code != tt.status {
+			t.Errorf("%s with %s role required on %s: status %d, expected %d", tt.user.Username, tt.role, tt.clientID, rec.Code, tt.status)
+		}
+	}
+}
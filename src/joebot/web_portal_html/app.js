@@ -12,8 +12,9 @@ new Vue({
 			{ key: 'ip', label: 'IP', sortable: true, tdAttr: {style:"width:8%;word-break:break-all;word-wrap:break-word;"} },
 			{ key: 'host_name', label: 'Hostname', sortable: true, tdAttr: {style:"width:10%;word-break:break-all;word-wrap:break-word;"} },
 			{ key: 'username', label: 'User', sortable: true, tdAttr: {style:"width:5%;word-break:break-all;word-wrap:break-word;"} },
+			{ key: 'online', label: 'Status', sortable: true, tdAttr: {style:"width:8%;word-break:break-all;word-wrap:break-word;"} },
 			{ key: 'port_tunnels', label: 'Port Tunnels', sortable: false, tdAttr: {style:"width:15%;word-break:break-all;word-wrap:break-word;"} },
-			{ key: 'tags', label: 'Tags', sortable: false, tdAttr: {style:"width:30%;word-break:break-all;word-wrap:break-word;"} },
+			{ key: 'tags', label: 'Tags', sortable: false, tdAttr: {style:"width:22%;word-break:break-all;word-wrap:break-word;"} },
 			{ key: 'actions', label: 'Actions', tdAttr: {style:"width:23%;word-break:break-all;word-wrap:break-word;"} }
 		],
 		currentPage: 1,
@@ -23,13 +24,29 @@ new Vue({
 		sortDesc: false,
 		filter: null,
 		modalInfo: { title: '', content: '' },
+		me: null,
 		
 		filteredItems: [],
 		selected: [],
 		selectAll: false,
 
 		targetClientId: null,
+
+		recordingsClientId: null,
+		recordings: [],
+		recordingFields: [
+			{ key: 'started_at', label: 'Started' },
+			{ key: 'ended_at', label: 'Ended' },
+			{ key: 'size', label: 'Size (Bytes)' },
+			{ key: 'actions', label: 'Actions' }
+		],
 		targetClientPortToBeCreated: null,
+		targetTunnelProtocol: 'tcp',
+		targetTunnelServerAddress: null,
+		targetTunnelName: null,
+		targetTunnelDescription: null,
+		targetTunnelTTL: null,
+		targetTunnelIdleTimeout: null,
 
 		targetJoebotServerAddr: null,
 		targetIPList: null,
@@ -73,6 +90,15 @@ new Vue({
 			this.filter = captured[1] ? decodeURIComponent(captured[1]) : null;
 		}
 
+		this.$http.get('/api/me').then(result => {
+			this.me = result.body;
+		}, response => {
+			// Without a basic auth challenge, the server expects a single sign-on login
+			if (response.status === 401 && !response.headers.get('WWW-Authenticate')) {
+				window.location = '/auth/login';
+			}
+		});
+
 		updateTable();
 		setInterval(() => updateTable(), 1000);
 	},
@@ -93,20 +119,58 @@ new Vue({
 			this.modalInfo.content = JSON.stringify(item, null, 3);
 			this.$root.$emit('bv::show::modal', 'modalInfo', button);
 		},
+		// Mirrors the role check of the server, which remains the one enforcing it
+		has_role (item, role) {
+			const levels = { 'viewer': 1, 'operator': 2, 'admin': 3 };
+			if (!this.me) {
+				return false;
+			}
+			if ((levels[this.me.role] || 0) >= levels[role]) {
+				return true;
+			}
+			if (item && item.tags && this.me.tag_roles) {
+				return item.tags.some(tag => (levels[this.me.tag_roles[tag]] || 0) >= levels[role]);
+			}
+			return false;
+		},
+		// CPU, memory and fullest disk usage of the last host metrics sample
+		metrics_summary (metrics) {
+			let memory = metrics.memory_total ? 100 * (1 - metrics.memory_available / metrics.memory_total) : 0;
+			let disk = Math.max(0, ...(metrics.disks || []).map(d => d.used_percent));
+			return `CPU ${metrics.cpu_percent.toFixed(0)}% · Mem ${memory.toFixed(0)}% · Disk ${disk.toFixed(0)}%`;
+		},
+		metrics_details (metrics) {
+			let lines = [`Load: ${metrics.load1} ${metrics.load5} ${metrics.load15}`, `Uptime: ${(metrics.uptime / 86400).toFixed(1)} days`];
+			for (let d of (metrics.disks || [])) {
+				lines.push(`${d.mount}: ${d.used_percent.toFixed(0)}% of ${(d.total / 1073741824).toFixed(1)} GB`);
+			}
+			return lines.join('\n');
+		},
+		format_bytes (bytes) {
+			let units = ['B', 'KB', 'MB', 'GB', 'TB'];
+			let i = 0;
+			for (; bytes >= 1024 && i < units.length - 1; i++) {
+				bytes /= 1024;
+			}
+			return `${bytes.toFixed(i ? 1 : 0)} ${units[i]}`;
+		},
+		traffic_summary (tunnel) {
+			let summary = `↓${this.format_bytes(tunnel.bytes_received)} ↑${this.format_bytes(tunnel.bytes_sent)}`;
+			return tunnel.rate_limit ? `${summary} · ${this.format_bytes(tunnel.rate_limit)}/s` : summary;
+		},
 		open_terminal (item) {
 			if( item.gotty_web_terminal_info ){
-				window.open(`http://${window.location.hostname}:${item.gotty_web_terminal_info.port_tunnel.server_port}`);
+				window.open(`/api/client/${item.id}/open/terminal`);
 			}
 		},
 		open_filebrowser (item) {
 			if( item.filebrowser_info ){
-				var postURL = "files" + encodeURI(item.filebrowser_info.default_directory);
-				window.open(`http://${window.location.hostname}:${item.filebrowser_info.port_tunnel.server_port}/${postURL}`);
+				window.open(`/api/client/${item.id}/open/files`);
 			}
 		},
 		open_vnc (item) {
 			if( item.novnc_websocket_info ){
-				window.open(`http://novnc.com/noVNC/vnc.html?host=${window.location.hostname}&port=${item.novnc_websocket_info.port_tunnel.server_port}&encrypt=0&autoconnect=1`);
+				window.open(`/api/client/${item.id}/open/vnc`);
 			}
 		},
 		resetModal () {
@@ -197,6 +261,15 @@ new Vue({
 			this.$refs.modalTargetIPList.focus();
 		},
 
+		show_recordings (item) {
+			this.recordingsClientId = item.id;
+			this.recordings = [];
+			this.$http.get(`/api/client/${item.id}/recordings`).then(result => {
+				this.recordings = result.body;
+			});
+			this.$refs.modalRecordings.show();
+		},
+
 		create_tunnel (item) {
 			this.targetClientId = item.id;
 			this.$refs.modalCreateTunnel.show();
@@ -215,14 +288,47 @@ new Vue({
 		},
 		handleSubmitTunnelCreation () {
 			let data = new FormData();
-			data.set('target_client_port', parseInt(this.targetClientPortToBeCreated))
+			if (this.targetTunnelServerAddress) {
+				data.set('target_address', this.targetTunnelServerAddress)
+				data.set('listen_port', parseInt(this.targetClientPortToBeCreated))
+			} else {
+				data.set('target_client_port', parseInt(this.targetClientPortToBeCreated))
+			}
+			data.set('protocol', this.targetTunnelProtocol)
+			data.set('name', this.targetTunnelName || '')
+			data.set('description', this.targetTunnelDescription || '')
+			data.set('ttl', Math.round((this.targetTunnelTTL || 0) * 60))
+			data.set('idle_timeout', Math.round((this.targetTunnelIdleTimeout || 0) * 60))
 			this.$http.post(`/api/client/${this.targetClientId}`, data).then(response => {
 				console.log(`Created Tunnel: \n${JSON.stringify(response.body, null, 3)}`);
+			}, response => {
+				alert(response.body.message);
 			});
 			
 			this.$refs.modalCreateTunnel.hide();
 			this.targetClientId = null;
 			this.targetClientPortToBeCreated = null;
+			this.targetTunnelProtocol = 'tcp';
+			this.targetTunnelServerAddress = null;
+			this.targetTunnelName = null;
+			this.targetTunnelDescription = null;
+			this.targetTunnelTTL = null;
+			this.targetTunnelIdleTimeout = null;
+		},
+		close_tunnel (item, portTunnel) {
+			let protocol = portTunnel.protocol || 'tcp';
+			let url = `/api/client/${item.id}/tunnels/${portTunnel.client_port}?protocol=${protocol}`;
+			let question = `Close the ${protocol} tunnel to client port ${portTunnel.client_port}?`;
+			if (portTunnel.listen && portTunnel.peer_client_id) {
+				url = `/api/client/${item.id}/peer-tunnels/${portTunnel.client_port}`;
+				question = `Close the tunnel from client port ${portTunnel.client_port} to ${portTunnel.peer_client_id}:${portTunnel.peer_port}?`;
+			}
+			if (!confirm(question)) {
+				return;
+			}
+			this.$http.delete(url).then(null, response => {
+				alert(response.body.message);
+			});
 		}
 	}
 });
\ No newline at end of file
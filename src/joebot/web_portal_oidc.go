@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/oidc"
+	"github.com/harmonicinc-com/joebot/server"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	sessionCookieName = "joebot_session"
+	stateCookieName   = "joebot_oidc_state"
+)
+
+// OIDCConfig holds the single sign-on settings of the web portal
+type OIDCConfig struct {
+	Flow          *oidc.LoginFlow
+	RedirectURL   string
+	UsernameClaim string
+	GroupsClaim   string
+	GroupMapping  map[string]string
+}
+
+func (config *OIDCConfig) redirectURL(c echo.Context) string {
+	if config.RedirectURL != "" {
+		return config.RedirectURL
+	}
+	return c.Scheme() + "://" + c.Request().Host + "/auth/callback"
+}
+
+func setCookie(c echo.Context, name string, value string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.IsTLS(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// registerOIDCRoutes adds the login and callback endpoints of the authorization code flow
+func registerOIDCRoutes(e *echo.Echo, s *server.Server, config *OIDCConfig) {
+	e.GET("/auth/login", func(c echo.Context) error {
+		state, authURL, err := config.Flow.Start(config.redirectURL(c))
+		if err != nil {
+			return err
+		}
+		// Binds the login to the browser which started it
+		setCookie(c, stateCookieName, state, 600)
+		return c.Redirect(http.StatusFound, authURL)
+	})
+	e.GET("/auth/callback", func(c echo.Context) error {
+		if errMsg := c.QueryParam("error"); errMsg != "" {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"message": "Login failed: " + errMsg + " " + c.QueryParam("error_description")})
+		}
+		stateCookie, err := c.Cookie(stateCookieName)
+		if err != nil || stateCookie.Value != c.QueryParam("state") {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": "Login state mismatch"})
+		}
+		setCookie(c, stateCookieName, "", -1)
+
+		claims, err := config.Flow.Finish(config.redirectURL(c), c.QueryParam("state"), c.QueryParam("code"))
+		if err != nil {
+			auditAs(c, s, "", models.AuditLogin, "", nil, err)
+			return c.JSON(http.StatusUnauthorized, echo.Map{"message": err.Error()})
+		}
+		username := claims.String(config.UsernameClaim)
+		if username == "" {
+			username = claims.String("sub")
+		}
+		user, err := server.MapGroupsToUser(username, claims.Strings(config.GroupsClaim), config.GroupMapping)
+		auditAs(c, s, username, models.AuditLogin, "", map[string]string{"groups": strings.Join(claims.Strings(config.GroupsClaim), ",")}, err)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, echo.Map{"message": err.Error()})
+		}
+
+		sessionID, err := s.CreateSession(user)
+		if err != nil {
+			return err
+		}
+		setCookie(c, sessionCookieName, sessionID, int(server.SessionTTL.Seconds()))
+		return c.Redirect(http.StatusFound, "/")
+	})
+}
+
+// registerLogoutRoute ends the session, and the one at the provider if single sign-on is enabled
+func registerLogoutRoute(e *echo.Echo, s *server.Server, config *OIDCConfig) {
+	e.GET("/auth/logout", func(c echo.Context) error {
+		if cookie, err := c.Cookie(sessionCookieName); err == nil {
+			s.DeleteSession(cookie.Value)
+		}
+		setCookie(c, sessionCookieName, "", -1)
+
+		if config == nil || config.Flow.Provider.EndSessionEndpoint == "" {
+			return c.Redirect(http.StatusFound, "/")
+		}
+		query := url.Values{
+			"client_id":                {config.Flow.Provider.ClientID},
+			"post_logout_redirect_uri": {c.Scheme() + "://" + c.Request().Host + "/"},
+		}
+		return c.Redirect(http.StatusFound, config.Flow.Provider.EndSessionEndpoint+"?"+query.Encode())
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harmonicinc-com/joebot/models"
+	"github.com/harmonicinc-com/joebot/server"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// Name of the query parameter carrying a ticket, used by the noVNC page which is served by another site
+const ticketParam = "ticket"
+
+// proxyPath is where the web portal serves a service of a client
+func proxyPath(clientID string, service string) string {
+	return "/c/" + clientID + "/" + service + "/"
+}
+
+// registerProxyRoutes serves the services of the clients through the web portal, so that only the web portal port has to be reachable
+func registerProxyRoutes(e *echo.Echo, s *server.Server, oidcConfig *OIDCConfig) {
+	g := e.Group("/c/:id", ticketMiddleware(s, authMiddleware(s, oidcConfig)), requireClientRole(s, models.RoleOperator))
+
+	g.Any("/terminal/*", proxyHandler(s, true, func(info models.ClientInfo, c echo.Context) *models.PortTunnelInfo {
+		if info.GottyWebTerminalInfo == nil {
+			return nil
+		}
+		return &info.GottyWebTerminalInfo.PortTunnelOnHost
+	}))
+	// The filebrowser is served under the same base URL, as its pages link to absolute paths
+	g.Any("/files/*", proxyHandler(s, false, func(info models.ClientInfo, c echo.Context) *models.PortTunnelInfo {
+		if info.FilebrowserInfo == nil || info.FilebrowserInfo.BaseURL == "" {
+			return nil
+		}
+		return &info.FilebrowserInfo.PortTunnelOnHost
+	}))
+	g.Any("/vnc/*", proxyHandler(s, true, func(info models.ClientInfo, c echo.Context) *models.PortTunnelInfo {
+		if info.NovncWebsocketInfo == nil {
+			return nil
+		}
+		return &info.NovncWebsocketInfo.PortTunnelOnHost
+	}))
+	g.Any("/port/:port/*", proxyHandler(s, true, func(info models.ClientInfo, c echo.Context) *models.PortTunnelInfo {
+		port, err := strconv.Atoi(c.Param("port"))
+		if err != nil {
+			return nil
+		}
+		for _, tunnel := range info.PortTunnels {
+			// UDP tunnels cannot carry HTTP, and tunnels between clients are not exposed
+			if tunnel.ClientPort == port && tunnel.Protocol != models.ProtocolUDP && tunnel.PeerClientID == "" {
+				return &tunnel
+			}
+		}
+		return nil
+	}))
+
+	// Pages of the services link to relative paths, which only resolve under the trailing slash
+	addSlash := func(c echo.Context) error {
+		return c.Redirect(http.StatusFound, c.Request().URL.Path+"/")
+	}
+	g.GET("/terminal", addSlash)
+	g.GET("/files", addSlash)
+	g.GET("/port/:port", addSlash)
+}
+
+// ticketMiddleware logs in users with a ticket issued for the client, or falls back to the given authentication
+func ticketMiddleware(s *server.Server, auth echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		authenticated := auth(next)
+		return func(c echo.Context) error {
+			if ticket := c.QueryParam(ticketParam); ticket != "" {
+				if user, ok := s.RedeemTicket(ticket, c.Param("id")); ok {
+					c.Set("user", user)
+					return next(c)
+				}
+				return c.JSON(http.StatusUnauthorized, echo.Map{"message": "Invalid or expired ticket"})
+			}
+			return authenticated(c)
+		}
+	}
+}
+
+// proxyHandler forwards requests, including websocket upgrades, to the server port of a tunnel of the client
+func proxyHandler(s *server.Server, stripPrefix bool, lookup func(models.ClientInfo, echo.Context) *models.PortTunnelInfo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		client, err := s.GetClientById(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"message": err.Error()})
+		}
+		tunnel := lookup(client.GetInfo(), c)
+		if tunnel == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"message": "Service is not available on client " + client.ID})
+		}
+
+		req := c.Request()
+		prefix := ""
+		if stripPrefix {
+			prefix = strings.NewReplacer(":id", c.Param("id"), ":port", c.Param("port")).Replace(strings.TrimSuffix(c.Path(), "/*"))
+		}
+		proxy := &httputil.ReverseProxy{
+			Director: func(r *http.Request) {
+				r.URL.Scheme = "http"
+				r.URL.Host = s.TunnelAddress(tunnel.ServerPort)
+				if prefix != "" {
+					r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+					r.URL.RawPath = ""
+				}
+				r.Header.Set("X-Forwarded-Host", req.Host)
+				r.Header.Set("X-Forwarded-Proto", c.Scheme())
+				removePortalCredentials(r)
+			},
+			ModifyResponse: func(resp *http.Response) error {
+				// Services must not be able to replace the cookies of the web portal
+				cookies := resp.Header["Set-Cookie"]
+				resp.Header.Del("Set-Cookie")
+				for _, cookie := range cookies {
+					if !strings.HasPrefix(strings.TrimSpace(cookie), "joebot_") {
+						resp.Header.Add("Set-Cookie", cookie)
+					}
+				}
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				c.Logger().Error(errors.Wrap(err, "Failed To Proxy Request To Client "+client.ID))
+				c.JSON(http.StatusBadGateway, echo.Map{"message": "Service of client " + client.ID + " is unreachable"})
+			},
+			FlushInterval: 100 * time.Millisecond,
+		}
+		proxy.ServeHTTP(c.Response(), req)
+		return nil
+	}
+}
+
+// removePortalCredentials keeps the credentials of the web portal user from reaching the client
+func removePortalCredentials(r *http.Request) {
+	r.Header.Del("Authorization")
+
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != sessionCookieName && cookie.Name != stateCookieName {
+			r.AddCookie(cookie)
+		}
+	}
+
+	query := r.URL.Query()
+	if query.Get(ticketParam) != "" {
+		query.Del(ticketParam)
+		r.URL.RawQuery = query.Encode()
+	}
+}